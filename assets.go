@@ -0,0 +1,217 @@
+package main
+
+import "bytes"
+import "crypto/sha256"
+import "crypto/sha512"
+import "encoding/base64"
+import "encoding/hex"
+import "io/ioutil"
+import "os"
+import "path"
+import "path/filepath"
+import "sort"
+import "strings"
+
+// A processed CSS or JavaScript asset
+type Asset struct {
+	Url       string
+	Integrity string
+}
+
+// Minifies and fingerprints the CSS and JavaScript files of the site's
+// 'static' directory, and builds the bundles declared in the asset_bundles
+// config. Assets are known to templates by their logical name, their path
+// relative to 'build/static', e.g. 'css/app.css'.
+type AssetPipeline struct {
+	Assets map[string]Asset
+
+	// Whether the urls or integrity of any asset changed since the previous
+	// build, in which case pages referring to them must be rebuilt
+	Changed bool
+
+	manager     *Manager
+	minify      bool
+	fingerprint bool
+
+	// The contents of the assets resolved by Preview
+	contents map[string][]byte
+}
+
+func NewAssetPipeline(m *Manager) *AssetPipeline {
+	return &AssetPipeline{Assets: make(map[string]Asset), manager: m, minify: m.Site.AssetMinify, fingerprint: m.Site.AssetFingerprint}
+}
+
+// Returns the asset with the given logical name. Names which are not CSS or
+// JavaScript assets resolve to their plain static url.
+func (ap *AssetPipeline) Lookup(name string) Asset {
+	name = strings.TrimPrefix(name, "/")
+	if asset, ok := ap.Assets[name]; ok {
+		return asset
+	}
+	return Asset{Url: RelURL(ap.manager.Site.Url, path.Join("static", name))}
+}
+
+// Reads the assets of the site. Returns the source files of the site's
+// static directory by logical name, the unprocessed contents of every asset
+// and the bundles declared in the config.
+func (ap *AssetPipeline) collect() (map[string]string, map[string][]byte, map[string][]string, error) {
+	files, _, err := ap.manager.StaticFiles()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sources := make(map[string]string)
+	for dest, source := range files {
+		rel, err := filepath.Rel("static", dest)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		sources[filepath.ToSlash(rel)] = source
+	}
+
+	contents := make(map[string][]byte)
+	for name, source := range sources {
+		if ext := path.Ext(name); ext == ".css" || ext == ".js" {
+			raw, err := ioutil.ReadFile(source)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			contents[name] = raw
+		}
+	}
+
+	bundles := ap.manager.Site.AssetBundles
+	names := make([]string, 0, len(bundles))
+	for name := range bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var bundle bytes.Buffer
+		for _, partname := range bundles[name] {
+			partname = strings.TrimPrefix(partname, "/")
+			source, ok := sources[partname]
+			if !ok {
+				return nil, nil, nil, &CustomError{"asset '" + partname + "' of bundle '" + name + "' does not exist"}
+			}
+			raw, err := ioutil.ReadFile(source)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			bundle.Write(raw)
+			// keep statements of concatenated scripts apart
+			if path.Ext(name) == ".js" {
+				bundle.WriteString(";")
+			}
+			bundle.WriteString("\n")
+		}
+		contents[name] = bundle.Bytes()
+	}
+	return sources, contents, bundles, nil
+}
+
+// Processes the assets among the static files of the site. Outputs of a
+// previous build which are no longer produced are removed. The processed
+// outputs and their integrity are recorded in the workspace '.goblinassets'
+// file. Without fingerprinting, assets keep the path of their static file,
+// which the minified output replaces.
+func (ap *AssetPipeline) Build() error {
+	staticdir := filepath.Join(ap.manager.Fspath, "build", "static")
+	sources, contents, _, err := ap.collect()
+	if err != nil {
+		return err
+	}
+
+	records := NewConfig(filepath.Join(ap.manager.Fspath, ".goblinassets"))
+	if Exists(records.filename) {
+		err = records.parse()
+		if err != nil {
+			return err
+		}
+	}
+	outputs := NewConfig(records.filename)
+
+	for name, raw := range contents {
+		if ap.minify {
+			switch path.Ext(name) {
+			case ".css":
+				raw = []byte(MinifyCSS(string(raw)))
+			case ".js":
+				raw = []byte(MinifyJS(string(raw)))
+			}
+		}
+
+		output := name
+		if ap.fingerprint {
+			hash := sha256.Sum256(raw)
+			ext := path.Ext(name)
+			output = strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(hash[:])[:8] + ext
+		}
+
+		target := filepath.Join(staticdir, filepath.FromSlash(output))
+		if current, err := ioutil.ReadFile(target); err != nil || !bytes.Equal(current, raw) {
+			err = os.MkdirAll(filepath.Dir(target), 0755)
+			if err != nil {
+				return err
+			}
+			err = ioutil.WriteFile(target, raw, 0644)
+			if err != nil {
+				return err
+			}
+		}
+		sum := sha512.Sum384(raw)
+		integrity := "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+		outputs.Set(output, integrity)
+		ap.Assets[name] = Asset{
+			Url:       RelURL(ap.manager.Site.Url, path.Join("static", output)),
+			Integrity: integrity,
+		}
+	}
+
+	for output := range outputs.data {
+		if records.GetString(output) != outputs.GetString(output) {
+			ap.Changed = true
+		}
+	}
+	for output := range records.data {
+		if _, ok := outputs.data[output]; ok {
+			continue
+		}
+		ap.Changed = true
+		// outputs which are now static files themselves are left alone
+		if _, ok := sources[output]; ok {
+			continue
+		}
+		err := os.Remove(filepath.Join(staticdir, filepath.FromSlash(output)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return SaveConfig(outputs)
+}
+
+// Resolves the assets of the site without writing any file, for previews.
+// Assets are neither minified nor fingerprinted, and bundles are kept in
+// memory to be served through Content.
+func (ap *AssetPipeline) Preview() error {
+	_, contents, _, err := ap.collect()
+	if err != nil {
+		return err
+	}
+	ap.contents = contents
+	for name, raw := range contents {
+		sum := sha512.Sum384(raw)
+		ap.Assets[name] = Asset{
+			Url:       RelURL(ap.manager.Site.Url, path.Join("static", name)),
+			Integrity: "sha384-" + base64.StdEncoding.EncodeToString(sum[:]),
+		}
+	}
+	return nil
+}
+
+// Returns the content of an asset resolved by Preview
+func (ap *AssetPipeline) Content(name string) ([]byte, bool) {
+	raw, ok := ap.contents[strings.TrimPrefix(name, "/")]
+	return raw, ok
+}
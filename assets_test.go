@@ -0,0 +1,65 @@
+package main
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+
+func TestMinifiedAssetsAcrossBuilds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	themedir := filepath.Join(dir, "themes", "plain")
+	err = os.MkdirAll(filepath.Join(themedir, "static"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(themedir, "static", "app.css"), []byte("body {\n    color: red;\n}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	site := DefaultSiteConfig()
+	site.AssetFingerprint = false
+	m := &Manager{Fspath: dir, Site: site, Theme: &Theme{Name: "plain", Path: themedir}}
+
+	build := func() SyncStats {
+		stats, _, err := m.SyncStatic()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewAssetPipeline(m).Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+	output := filepath.Join(dir, "build", "static", "app.css")
+
+	build()
+	first, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := ioutil.ReadFile(output); string(raw) != "body{color:red}" {
+		t.Errorf("first build wrote %q, want the minified stylesheet", raw)
+	}
+
+	stats := build()
+	if stats.Copied != 0 {
+		t.Errorf("second build copied %d static files, want 0", stats.Copied)
+	}
+	second, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.ModTime().Equal(first.ModTime()) {
+		t.Errorf("second build rewrote the minified stylesheet")
+	}
+	if raw, _ := ioutil.ReadFile(output); string(raw) != "body{color:red}" {
+		t.Errorf("second build left %q, want the minified stylesheet", raw)
+	}
+}
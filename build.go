@@ -0,0 +1,290 @@
+package main
+
+import "fmt"
+import "os"
+import "path/filepath"
+import "time"
+
+// Builds the workspace of a manager into its 'build' directory
+type Builder struct {
+	Manager *Manager
+	Verbose bool
+
+	// Whether pages marked as drafts are rendered, whatever the drafts
+	// config
+	Drafts bool
+
+	sitePages   []map[string]interface{}
+	themeParams map[string]interface{}
+	images      *ImageProcessor
+}
+
+func NewBuilder(manager *Manager, verbose bool) *Builder {
+	return &Builder{Manager: manager, Verbose: verbose}
+}
+
+// Whether drafts are rendered, by the builder or the site configuration
+func (b *Builder) drafts() bool {
+	return b.Drafts || b.Manager.Site.Drafts
+}
+
+func (b *Builder) infof(format string, args ...interface{}) {
+	IfTrueExec(b.Verbose, OUT.Infof, format, args...)
+}
+
+// Prepares the site for rendering: loads its pages and theme, and resolves
+// the theme parameters.
+func (b *Builder) Load() error {
+	m := b.Manager
+	err := m.LoadPages()
+	if err != nil {
+		return err
+	}
+	if m.Theme == nil {
+		err := m.LoadTheme()
+		if err != nil {
+			return err
+		}
+	}
+	b.sitePages = make([]map[string]interface{}, 0, len(m.Pages))
+	list, err := m.PageList()
+	if err != nil {
+		return err
+	}
+	for _, params := range list {
+		if params["draft"] == true && !b.drafts() {
+			continue
+		}
+		b.sitePages = append(b.sitePages, params)
+	}
+
+	params, warnings, err := m.Theme.Params(m.Site.ThemeParams)
+	if err != nil {
+		return &CustomError{"invalid theme parameters: " + err.Error()}
+	}
+	for _, warning := range warnings {
+		OUT.Infof("warning: %s", warning)
+	}
+	b.themeParams = params
+	b.images = NewImageProcessor(m)
+	return nil
+}
+
+// Builds the site. Only pages modified since the last build are rendered
+// unless all is set, or the site pages, the urls of the site's assets or
+// the images it refers to changed.
+func (b *Builder) Build(all bool) error {
+	m := b.Manager
+	err := b.Load()
+	if err != nil {
+		return err
+	}
+
+	b.infof("synchronizing static directories\n")
+	stats, warnings, err := m.SyncStatic()
+	if err != nil {
+		return &CustomError{"cannot synchronize static files: " + err.Error()}
+	}
+	b.infof("static files: %d copied, %d unchanged, %d removed", stats.Copied, stats.Unchanged, stats.Removed)
+	for _, warning := range warnings {
+		OUT.Infof("warning: %s", warning)
+	}
+
+	// assets are processed before the pages so that layouts can refer to
+	// their fingerprinted urls
+	b.infof("processing assets\n")
+	m.Assets = NewAssetPipeline(m)
+	err = m.Assets.Build()
+	if err != nil {
+		return &CustomError{"cannot process assets: " + err.Error()}
+	}
+
+	// pages refer to the sizes of their images in srcset attributes
+	images, err := b.images.Changed()
+	if err != nil {
+		return &CustomError{"cannot process images: " + err.Error()}
+	}
+
+	pages := m.CheckPages(all || m.Assets.Changed || images, b.sitePages)
+	minify := m.Site.MinifyHTML
+	before, after, minified := 0, 0, 0
+
+	for i := 0; i < len(pages); i++ {
+		page, err := m.LoadPage(pages[i])
+		if err != nil {
+			return err
+		}
+		if page.Draft && !b.drafts() {
+			b.infof("skipping draft '%s'", pages[i].Name())
+			// the page may have been built before it became a draft
+			err = os.Remove(b.OutputName(&page))
+			if err != nil && !os.IsNotExist(err) {
+				OUT.Errorf("could not remove draft %s: %s", page.Fi.Name(), err)
+			}
+			continue
+		}
+		b.infof("now building '%s'", pages[i].Name())
+
+		out, err := b.RenderPage(&page)
+		if err != nil {
+			return &CustomError{fmt.Sprintf("could not render %s: %s", page.Fi.Name(), err)}
+		}
+		out = b.images.RewriteImages(out, page.Permalink())
+
+		if minify {
+			before += len(out)
+			out = MinifyHTML(out)
+			after += len(out)
+			minified++
+		}
+
+		err = b.WritePage(&page, out)
+		if err != nil {
+			OUT.Errorf("could not build %s: %s", page.Fi.Name(), err)
+		}
+	}
+	m.SaveRecords(b.sitePages)
+
+	err = b.BuildNotFound()
+	if err != nil {
+		return &CustomError{"could not render the 404 page: " + err.Error()}
+	}
+
+	b.infof("writing redirects and headers\n")
+	err = b.BuildRedirects()
+	if err != nil {
+		return &CustomError{"could not write redirects: " + err.Error()}
+	}
+	err = b.BuildHeaders()
+	if err != nil {
+		return &CustomError{"could not write headers: " + err.Error()}
+	}
+
+	if minify && before > 0 {
+		OUT.Infof("minified html of %d pages: %d bytes saved (%.1f%%)", minified, before-after, 100*float64(before-after)/float64(before))
+	}
+
+	b.infof("processing images\n")
+	err = b.images.Process()
+	if err != nil {
+		return &CustomError{"cannot process images: " + err.Error()}
+	}
+
+	// also run when compression is disabled, to remove stale files
+	b.infof("precompressing outputs\n")
+	written, err := m.Precompress()
+	if err != nil {
+		return &CustomError{"cannot precompress outputs: " + err.Error()}
+	}
+	b.infof("wrote %d compressed files", written)
+	return nil
+}
+
+// Returns the values passed to the layout of a page
+func (b *Builder) PageContext(page *Page) TemplateContext {
+	m := b.Manager
+	return TemplateContext{
+		"site_title":     m.Site.Title,
+		"site_url":       m.Site.Url,
+		"site_author":    m.Site.Author,
+		"site_copyright": fmt.Sprintf(m.Site.Copyright, time.Now().Year()),
+
+		"site_pages": b.sitePages,
+
+		"site": map[string]interface{}{
+			"title":  m.Site.Title,
+			"url":    m.Site.Url,
+			"author": m.Site.Author,
+			"env":    m.Env,
+			// the whole configuration, custom sections included
+			"params": m.Config.data,
+		},
+
+		"theme": map[string]interface{}{
+			"name":   m.Theme.Name,
+			"params": b.themeParams,
+		},
+
+		"page_title":  page.Title,
+		"page_author": page.Author,
+		"page_date":   page.Date,
+		"page_url":    page.Permalink(),
+		"page":        page.Params(),
+
+		"content": string(RenderMarkdown(page.Content)),
+
+		"goblin_powered": `This website powered by the <a href="https://github.com/aisola/goblin.git" target="_blank">Goblin</a> Static Site Directory.`,
+	}
+}
+
+// Renders a page through its layout
+func (b *Builder) RenderPage(page *Page) (string, error) {
+	return RenderTheme(b.Manager, page.Layout, b.PageContext(page))
+}
+
+// Returns the file of the build directory a page is written to
+func (b *Builder) OutputName(page *Page) string {
+	if page.Url == "" {
+		return filepath.Join(b.Manager.Fspath, "build", filepath.FromSlash(page.Permalink()))
+	}
+	return filepath.Join(b.Manager.Fspath, "build", page.Url, "index.html")
+}
+
+// Writes the rendered html of a page to its place in the build directory
+func (b *Builder) WritePage(page *Page, html string) error {
+	html_name := b.OutputName(page)
+	err := os.MkdirAll(filepath.Dir(html_name), 0755)
+	if err != nil {
+		return &CustomError{"cannot create necessary directory: " + err.Error()}
+	}
+
+	_ = os.Remove(html_name)
+	return CreateSimpleFile(html_name, html, 0644)
+}
+
+// Renders the theme's 404 layout to 'build/404.html', which is served for
+// missing paths. A site page built to the same file takes precedence.
+func (b *Builder) BuildNotFound() error {
+	m := b.Manager
+	page, ok := b.NotFoundPage()
+	if !ok {
+		return nil
+	}
+	out, err := b.RenderPage(page)
+	if err != nil {
+		return err
+	}
+	out = b.images.RewriteImages(out, page.Permalink())
+	if m.Site.MinifyHTML {
+		out = MinifyHTML(out)
+	}
+
+	// left untouched when unchanged, so that it is not compressed again
+	return writeIfChanged(filepath.Join(m.Fspath, "build", "404.html"), []byte(out))
+}
+
+// Returns the page rendering the theme's 404 layout, unless the theme has
+// none or a site page takes its place
+func (b *Builder) NotFoundPage() (*Page, bool) {
+	if _, ok := b.Manager.FindLayout("404"); !ok {
+		return nil, false
+	}
+	for _, fi := range b.Manager.Pages {
+		if fi.Name() == "404.md" {
+			return nil, false
+		}
+	}
+	return &Page{Fi: virtualFile{"404.md"}, Title: "Page Not Found", Layout: "404"}, true
+}
+
+// The file info of a page without a source file
+type virtualFile struct {
+	name string
+}
+
+func (f virtualFile) Name() string       { return f.name }
+func (f virtualFile) Size() int64        { return 0 }
+func (f virtualFile) Mode() os.FileMode  { return 0644 }
+func (f virtualFile) ModTime() time.Time { return time.Time{} }
+func (f virtualFile) IsDir() bool        { return false }
+func (f virtualFile) Sys() interface{}   { return nil }
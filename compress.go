@@ -0,0 +1,136 @@
+package main
+
+import "compress/gzip"
+import "io"
+import "os"
+import "path/filepath"
+import "strings"
+
+import "github.com/andybalholm/brotli"
+
+// The extensions of build outputs worth compressing
+var CompressibleExts = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true,
+	".json": true, ".xml": true, ".svg": true, ".txt": true, ".map": true,
+	".webmanifest": true, ".ico": true,
+}
+
+// A content encoding outputs are precompressed with
+type Encoding struct {
+	Name string
+	Ext  string
+	New  func(io.Writer) io.WriteCloser
+}
+
+// The supported encodings, in order of preference when serving
+var Encodings = []Encoding{
+	{"br", ".br", func(w io.Writer) io.WriteCloser { return brotli.NewWriterLevel(w, brotli.BestCompression) }},
+	{"gzip", ".gz", func(w io.Writer) io.WriteCloser {
+		gz, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return gz
+	}},
+}
+
+// Returns the encodings enabled by the compress_gzip and compress_brotli
+// config
+func (m *Manager) enabledEncodings() []Encoding {
+	enabled := make([]Encoding, 0)
+	for _, encoding := range Encodings {
+		if encoding.Name == "br" && m.Site.CompressBrotli ||
+			encoding.Name == "gzip" && m.Site.CompressGzip {
+			enabled = append(enabled, encoding)
+		}
+	}
+	return enabled
+}
+
+// Writes a compressed sibling (e.g. 'index.html.gz') of every compressible
+// output in the build directory at least compress_min_size bytes large, for
+// each enabled encoding. Siblings newer than their output are kept, and
+// those of removed or no longer compressed outputs are deleted. Returns the
+// number of siblings written.
+func (m *Manager) Precompress() (int, error) {
+	builddir := filepath.Join(m.Fspath, "build")
+	enabled := m.enabledEncodings()
+	minsize := int64(m.Site.CompressMinSize)
+	written := 0
+
+	err := filepath.Walk(builddir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		// remove stale siblings
+		for _, encoding := range Encodings {
+			if !strings.HasSuffix(path, encoding.Ext) {
+				continue
+			}
+			source := strings.TrimSuffix(path, encoding.Ext)
+			if !CompressibleExts[strings.ToLower(filepath.Ext(source))] {
+				continue
+			}
+			si, err := os.Stat(source)
+			if err != nil || si.Size() < minsize || !encodingEnabled(enabled, encoding.Name) {
+				return os.Remove(path)
+			}
+			return nil
+		}
+
+		if !CompressibleExts[strings.ToLower(filepath.Ext(path))] || fi.Size() < minsize {
+			return nil
+		}
+		for _, encoding := range enabled {
+			sibling := path + encoding.Ext
+			if ci, err := os.Stat(sibling); err == nil && !ci.ModTime().Before(fi.ModTime()) {
+				continue
+			}
+			err := compressFile(path, sibling, encoding)
+			if err != nil {
+				return err
+			}
+			written++
+		}
+		return nil
+	})
+	return written, err
+}
+
+func encodingEnabled(enabled []Encoding, name string) bool {
+	for _, encoding := range enabled {
+		if encoding.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Compresses the file source into dest with the given encoding. The output
+// is written to a temporary file first so that a server never sees a
+// partially written sibling.
+func compressFile(source, dest string, encoding Encoding) error {
+	sf, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sf.Close()
+
+	tmp := dest + ".tmp"
+	df, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := encoding.New(df)
+	_, err = io.Copy(w, sf)
+	if err == nil {
+		err = w.Close()
+	}
+	if cerr := df.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
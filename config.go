@@ -1,8 +1,13 @@
 package main
 
 import "encoding/json"
-import "log"
+import "fmt"
+import "io/ioutil"
+import "math"
 import "os"
+import "path/filepath"
+import "strconv"
+import "strings"
 
 type Config struct {
 	data     map[string]interface{}
@@ -16,41 +21,58 @@ func NewConfig(filename string) *Config {
 	return result
 }
 
-// Saves a *Config into its marshaled json
+// Saves a *Config into its marshaled json. The file is replaced atomically,
+// so that an interrupted save never leaves it truncated. YAML and TOML
+// files are not rewritten as json; edit them with EditConfig instead.
 func SaveConfig(config *Config) error {
-    file, err := os.OpenFile(config.filename, os.O_CREATE, os.FileMode(0644))
-    defer file.Close()
-	if err != nil { return err }
+    switch strings.ToLower(filepath.Ext(config.filename)) {
+    case ".yaml", ".yml", ".toml":
+        return &CustomError{"cannot save " + config.filename + " as json"}
+    }
     
     jsondata, err := json.MarshalIndent(config.data, "", "    ")
 	if err != nil { return err }
     
-    file.Write(jsondata)
-    return nil
+    return WriteFileAtomic(config.filename, append(jsondata, '\n'), 0644)
 }
 
 // Loads config information from a JSON file
-func LoadConfig(filename string) *Config {
+func LoadConfig(filename string) (*Config, error) {
 	result := NewConfig(filename)
-	result.filename = filename
 	err := result.parse()
 	if err != nil {
-		log.Fatalf("error loading config file %s: %s", filename, err)
+		return nil, &CustomError{fmt.Sprintf("error loading config file %s: %s", filename, err)}
 	}
-	return result
+	return result, nil
 }
 
 // Loads config information from a JSON string
-func LoadConfigString(s string) *Config {
+func LoadConfigString(s string) (*Config, error) {
 	result := NewConfig("")
 	err := json.Unmarshal([]byte(s), &result.data)
 	if err != nil {
-		log.Fatalf("error parsing config string %s: %s", s, err)
+		return nil, &CustomError{fmt.Sprintf("error parsing config string %s: %s", s, err)}
 	}
-	return result
+	return result, nil
 }
 
+// Parses the config file, in the format its extension names: JSON unless
+// it is a YAML or TOML file
 func (c *Config) parse() error {
+	switch strings.ToLower(filepath.Ext(c.filename)) {
+	case ".yaml", ".yml", ".toml":
+		raw, err := ioutil.ReadFile(c.filename)
+		if err != nil {
+			return err
+		}
+		data, err := decodeConfig(raw, filepath.Ext(c.filename))
+		if err != nil {
+			return err
+		}
+		c.data = data
+		return nil
+	}
+
 	f, err := os.Open(c.filename)
 	if err != nil {
 		return err
@@ -65,52 +87,240 @@ func (c *Config) parse() error {
 	return nil
 }
 
+// Whether the config has a value for key, which may be a dotted path
+func (c *Config) Has(key string) bool {
+	_, present := c.Lookup(key)
+	return present
+}
+
 // Set an object to the config
 func (c *Config) Set(key string, thing interface{}) {
     c.data[key] = thing
 }
 
-// Returns a string for the config variable key
-func (c *Config) GetString(key string) string {
-	result, present := c.data[key]
-	if !present {
-		return ""
+// Sets the value at a dotted path of the config, creating the objects along
+// the path which do not exist yet. Lists along the path must already hold
+// the indexed item. Paths which are also keys holding dots are refused.
+func (c *Config) SetPath(path string, value interface{}) error {
+	steps, ok := splitPath(path)
+	if !ok {
+		return &CustomError{"invalid config path '" + path + "'"}
 	}
-	return result.(string)
+	// Lookup matches keys holding dots first, so that setting the nested
+	// path would leave the key read unchanged
+	if _, present := c.data[path]; present && len(steps) > 1 {
+		return &CustomError{"config path '" + path + "' is also a key holding dots; edit it by hand"}
+	}
+
+	var current interface{} = c.data
+	for i, step := range steps {
+		last := i == len(steps)-1
+		switch step := step.(type) {
+		case string:
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return &CustomError{"config path '" + path + "' does not lead through objects"}
+			}
+			if last {
+				object[step] = value
+				return nil
+			}
+			if _, ok := object[step]; !ok {
+				if _, index := steps[i+1].(int); index {
+					return &CustomError{"config path '" + path + "' indexes a missing list"}
+				}
+				object[step] = make(map[string]interface{})
+			}
+			current = object[step]
+		case int:
+			list, ok := current.([]interface{})
+			if !ok || step < 0 || step >= len(list) {
+				return &CustomError{"config path '" + path + "' indexes past a list"}
+			}
+			if last {
+				list[step] = value
+				return nil
+			}
+			current = list[step]
+		}
+	}
+	return nil
 }
 
-// Returns an int for the config variable key
+// Merges other into the config: objects are merged key by key, recursively,
+// and any other value of other replaces that of the config
+func (c *Config) Merge(other *Config) {
+	c.data = mergeObjects(c.data, other.data)
+}
+
+func mergeObjects(base, overlay map[string]interface{}) map[string]interface{} {
+	for key, value := range overlay {
+		if object, ok := value.(map[string]interface{}); ok {
+			if current, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergeObjects(current, object)
+				continue
+			}
+		}
+		base[key] = value
+	}
+	return base
+}
+
+// Returns the value at a path of the config: a key, or a dotted path into
+// nested objects and lists such as 'menus.main[0].url'. Keys holding dots
+// themselves are matched first.
+func (c *Config) Lookup(path string) (interface{}, bool) {
+	if result, present := c.data[path]; present {
+		return result, true
+	}
+	steps, ok := splitPath(path)
+	if !ok {
+		return nil, false
+	}
+
+	var current interface{} = c.data
+	for _, step := range steps {
+		switch step := step.(type) {
+		case string:
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			current, ok = object[step]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			list, ok := current.([]interface{})
+			if !ok || step < 0 || step >= len(list) {
+				return nil, false
+			}
+			current = list[step]
+		}
+	}
+	return current, true
+}
+
+// Splits a dotted path into its keys and list indices
+func splitPath(path string) ([]interface{}, bool) {
+	steps := make([]interface{}, 0)
+	for _, segment := range strings.Split(path, ".") {
+		open := strings.Index(segment, "[")
+		if open < 0 {
+			open = len(segment)
+		}
+		if open == 0 && len(steps) == 0 || segment == "" {
+			return nil, false
+		}
+		if open > 0 {
+			steps = append(steps, segment[:open])
+		}
+		for rest := segment[open:]; rest != ""; {
+			close := strings.Index(rest, "]")
+			if rest[0] != '[' || close < 0 {
+				return nil, false
+			}
+			index, err := strconv.Atoi(rest[1:close])
+			if err != nil {
+				return nil, false
+			}
+			steps = append(steps, index)
+			rest = rest[close+1:]
+		}
+	}
+	return steps, true
+}
+
+// Returns the string at a path of the config
+func (c *Config) LookupString(path string) (string, bool) {
+	x, _ := c.Lookup(path)
+	result, ok := x.(string)
+	return result, ok
+}
+
+// Returns the integer at a path of the config. Numbers with a fraction are
+// not integers.
+func (c *Config) LookupInt(path string) (int, bool) {
+	x, _ := c.Lookup(path)
+	result, ok := x.(float64)
+	if !ok || result != math.Trunc(result) {
+		return 0, false
+	}
+	return int(result), true
+}
+
+// Returns the number at a path of the config
+func (c *Config) LookupFloat(path string) (float64, bool) {
+	x, _ := c.Lookup(path)
+	result, ok := x.(float64)
+	return result, ok
+}
+
+// Returns the bool at a path of the config
+func (c *Config) LookupBool(path string) (bool, bool) {
+	x, _ := c.Lookup(path)
+	result, ok := x.(bool)
+	return result, ok
+}
+
+// Returns the array at a path of the config
+func (c *Config) LookupArray(path string) ([]interface{}, bool) {
+	x, _ := c.Lookup(path)
+	result, ok := x.([]interface{})
+	return result, ok
+}
+
+// Returns the object at a path of the config
+func (c *Config) LookupMap(path string) (map[string]interface{}, bool) {
+	x, _ := c.Lookup(path)
+	result, ok := x.(map[string]interface{})
+	return result, ok
+}
+
+// Returns a string for the config variable key, or "" if it is missing or
+// not a string. Like the other getters, it accepts dotted paths.
+func (c *Config) GetString(key string) string {
+	result, _ := c.LookupString(key)
+	return result
+}
+
+// Returns an int for the config variable key, or -1 if it is missing or not
+// a number
 func (c *Config) GetInt(key string) int {
-	x, ok := c.data[key]
+	x, ok := c.LookupFloat(key)
 	if !ok {
 		return -1
 	}
-	return int(x.(float64))
+	return int(x)
 }
 
-// Returns a float for the config variable key
+// Returns a float for the config variable key, or -1 if it is missing or not
+// a number
 func (c *Config) GetFloat(key string) float64 {
-	x, ok := c.data[key]
+	x, ok := c.LookupFloat(key)
 	if !ok {
 		return -1
 	}
-	return x.(float64)
+	return x
 }
 
-// Returns a bool for the config variable key
+// Returns a bool for the config variable key, or false if it is missing or
+// not a bool
 func (c *Config) GetBool(key string) bool {
-	x, ok := c.data[key]
-	if !ok {
-		return false
-	}
-	return x.(bool)
+	x, _ := c.LookupBool(key)
+	return x
 }
 
-// Returns an array for the config variable key
+// Returns an array for the config variable key, or nil if it is missing or
+// not an array
 func (c *Config) GetArray(key string) []interface{} {
-	result, present := c.data[key]
-	if !present {
-		return []interface{}(nil)
-	}
-	return result.([]interface{})
-}
\ No newline at end of file
+	result, _ := c.LookupArray(key)
+	return result
+}
+
+// Returns a map for the config variable key, or nil if it is missing or not
+// a map
+func (c *Config) GetMap(key string) map[string]interface{} {
+	result, _ := c.LookupMap(key)
+	return result
+}
@@ -0,0 +1,173 @@
+package main
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "reflect"
+import "testing"
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []interface{}
+		ok   bool
+	}{
+		{"title", []interface{}{"title"}, true},
+		{"social.twitter", []interface{}{"social", "twitter"}, true},
+		{"menus.main[0].url", []interface{}{"menus", "main", 0, "url"}, true},
+		{"grid[1][2]", []interface{}{"grid", 1, 2}, true},
+		{"títle.ünïcode", []interface{}{"títle", "ünïcode"}, true},
+		{"", nil, false},
+		{"a..b", nil, false},
+		{"a.", nil, false},
+		{"[0]", nil, false},
+		{"a[x]", nil, false},
+		{"a[0", nil, false},
+		{"a[0]b", nil, false},
+	}
+	for _, test := range tests {
+		got, ok := splitPath(test.path)
+		if ok != test.ok || ok && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitPath(%q) = %v, %v, want %v, %v", test.path, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+const testConfig = `{
+	"title": "Site",
+	"image_quality": 80,
+	"drafts": true,
+	"a.b": "dotted key",
+	"a": {"b": "nested"},
+	"social": {"twitter": "@site"},
+	"menus": {"main": [{"url": "/"}, {"url": "/about/"}]},
+	"widths": [320, 640]
+}`
+
+func TestLookup(t *testing.T) {
+	config, err := LoadConfigString(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want interface{}
+		ok   bool
+	}{
+		{"title", "Site", true},
+		{"a.b", "dotted key", true},
+		{"social.twitter", "@site", true},
+		{"menus.main[1].url", "/about/", true},
+		{"widths[0]", float64(320), true},
+		{"widths[2]", nil, false},
+		{"widths[-1]", nil, false},
+		{"title.x", nil, false},
+		{"social[0]", nil, false},
+		{"missing", nil, false},
+		{"menus.main[0].missing", nil, false},
+	}
+	for _, test := range tests {
+		got, ok := config.Lookup(test.path)
+		if ok != test.ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Lookup(%q) = %v, %v, want %v, %v", test.path, got, ok, test.want, test.ok)
+		}
+	}
+
+	if got := config.GetString("social.twitter"); got != "@site" {
+		t.Errorf("GetString = %q", got)
+	}
+	if got := config.GetInt("image_quality"); got != 80 {
+		t.Errorf("GetInt = %d", got)
+	}
+	if got := config.GetInt("missing"); got != -1 {
+		t.Errorf("GetInt of a missing key = %d, want -1", got)
+	}
+	if got := config.GetString("image_quality"); got != "" {
+		t.Errorf("GetString of a number = %q, want \"\"", got)
+	}
+	if got := config.GetBool("drafts"); !got {
+		t.Errorf("GetBool = %v", got)
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		value interface{}
+		err   bool
+	}{
+		{"title", "New", false},
+		{"social.github", "gh", false},
+		{"new.nested.key", true, false},
+		{"menus.main[0].url", "/home/", false},
+		{"widths[1]", float64(800), false},
+		{"widths[2]", float64(1), true},
+		{"title.x", "y", true},
+		{"missing[0]", "y", true},
+		{"a..b", "y", true},
+		{"a.b", "y", true},
+	}
+	for _, test := range tests {
+		config, _ := LoadConfigString(testConfig)
+		err := config.SetPath(test.path, test.value)
+		if (err != nil) != test.err {
+			t.Errorf("SetPath(%q) error = %v, want error %v", test.path, err, test.err)
+			continue
+		}
+		if got, _ := config.Lookup(test.path); !test.err && !reflect.DeepEqual(got, test.value) {
+			t.Errorf("SetPath(%q): value = %v, want %v", test.path, got, test.value)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	config, _ := LoadConfigString(`{"title": "Site", "social": {"twitter": "a", "github": "b"}, "widths": [1, 2]}`)
+	overlay, _ := LoadConfigString(`{"url": "http://localhost", "social": {"twitter": "c"}, "widths": [3]}`)
+	config.Merge(overlay)
+	want := map[string]interface{}{
+		"title":  "Site",
+		"url":    "http://localhost",
+		"social": map[string]interface{}{"twitter": "c", "github": "b"},
+		"widths": []interface{}{float64(3)},
+	}
+	if !reflect.DeepEqual(config.data, want) {
+		t.Errorf("merged config = %v, want %v", config.data, want)
+	}
+}
+
+func TestConfigSetDottedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	raw := "{\n    \"a.b\": \"dotted\",\n    \"c.d\": \"other\"\n}\n"
+	err = ioutil.WriteFile(filename, []byte(raw), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get reads the dotted key, so set must not write a nested one
+	if _, err := ConfigSet(dir, "a.b", "new"); err == nil {
+		t.Errorf("ConfigSet of a path which is a key holding dots succeeded")
+	}
+	if current, _ := ioutil.ReadFile(filename); string(current) != raw {
+		t.Errorf("refused ConfigSet changed the config to %q", current)
+	}
+
+	if _, err := ConfigSet(dir, "a.c", "new"); err != nil {
+		t.Fatalf("ConfigSet(a.c) = %v", err)
+	}
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := config.Lookup("a.b"); got != "dotted" {
+		t.Errorf("a.b = %v after setting a.c, want the dotted key", got)
+	}
+	if got, _ := config.Lookup("a.c"); got != "new" {
+		t.Errorf("a.c = %v, want new", got)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import "encoding/json"
+import "fmt"
+import "io/ioutil"
+import "path/filepath"
+import "reflect"
+import "sort"
+import "strings"
+
+// Prints the value of a config key of a workspace, as seen in an
+// environment: strings as they are, anything else as json
+func ConfigGet(dir, env, path string) error {
+	config, warnings, err := LoadEnvConfig(dir, env)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		OUT.Infof("warning: %s", warning)
+	}
+	value, ok := config.Lookup(path)
+	if !ok {
+		return &CustomError{"config key '" + path + "' is not set"}
+	}
+	if s, ok := value.(string); ok {
+		fmt.Println(s)
+		return nil
+	}
+	encoded, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(encoded))
+	return nil
+}
+
+// Prints every value of the config of a workspace, as seen in an
+// environment, one 'path = json' line per value
+func ConfigList(dir, env string) error {
+	config, warnings, err := LoadEnvConfig(dir, env)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		OUT.Infof("warning: %s", warning)
+	}
+	lines := make([]string, 0)
+	flattenConfig("", config.data, func(path string, value interface{}) {
+		encoded, _ := json.Marshal(value)
+		lines = append(lines, path+" = "+string(encoded))
+	})
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// Calls fn with the path of every value nested in objects, lists and empty
+// objects included
+func flattenConfig(prefix string, value interface{}, fn func(string, interface{})) {
+	object, ok := value.(map[string]interface{})
+	if !ok || len(object) == 0 && prefix != "" {
+		fn(prefix, value)
+		return
+	}
+	for key, child := range object {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		flattenConfig(key, child, fn)
+	}
+}
+
+// Sets a config key in the config file of a workspace, editing the file in
+// place so that its comments and layout are kept. The value is decoded as
+// json unless the key holds a string. Values making the config invalid are
+// refused, and the file is replaced atomically.
+func ConfigSet(dir, path, raw string) ([]string, error) {
+	filename, err := FindConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	config, err := LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	value := config.decodeValue(path, raw)
+	err = config.SetPath(path, value)
+	if err != nil {
+		return nil, err
+	}
+	_, warnings, err := NewSiteConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	edited, err := EditConfig(contents, filename, path, value)
+	if err != nil {
+		return nil, err
+	}
+
+	// make sure the edited file reads back as the config just validated
+	var data map[string]interface{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml", ".toml":
+		data, err = decodeConfig(edited, filepath.Ext(filename))
+	default:
+		err = json.Unmarshal(edited, &data)
+	}
+	if err != nil || !reflect.DeepEqual(data, config.data) {
+		return nil, &CustomError{"cannot edit '" + filepath.Base(filename) + "' in place; edit it by hand"}
+	}
+	return warnings, WriteFileAtomic(filename, edited, 0644)
+}
@@ -0,0 +1,569 @@
+package main
+
+import "encoding/json"
+import "fmt"
+import "path/filepath"
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+
+// Returns the content of a config file with the value at a dotted path set,
+// leaving the rest of the file as it was: comments, key order and layout are
+// kept. Objects missing along the path are created. The format is the one
+// the extension of name implies.
+func EditConfig(raw []byte, name, path string, value interface{}) ([]byte, error) {
+	steps, ok := splitPath(path)
+	if !ok {
+		return nil, &CustomError{"invalid config path '" + path + "'"}
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return editYAML(raw, path, steps, value)
+	case ".toml":
+		return editTOML(raw, path, steps, value)
+	}
+	return editJSON(raw, path, steps, value)
+}
+
+// Nests a value in the objects named by the remaining steps of a path
+func nestValue(path string, steps []interface{}, value interface{}) (interface{}, error) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		key, ok := steps[i].(string)
+		if !ok {
+			return nil, &CustomError{"config path '" + path + "' indexes a missing list"}
+		}
+		value = map[string]interface{}{key: value}
+	}
+	return value, nil
+}
+
+// Returns the whitespace indenting the line holding position pos
+func lineIndent(raw []byte, pos int) string {
+	start := pos
+	for start > 0 && raw[start-1] != '\n' {
+		start--
+	}
+	end := start
+	for end < len(raw) && (raw[end] == ' ' || raw[end] == '\t') {
+		end++
+	}
+	return string(raw[start:end])
+}
+
+// Scans the tokens of a json document, keeping track of their positions
+type jsonScanner struct {
+	raw []byte
+	pos int
+}
+
+func (s *jsonScanner) space() {
+	for s.pos < len(s.raw) && strings.IndexByte(" \t\r\n", s.raw[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+func (s *jsonScanner) fail(message string) error {
+	return &CustomError{fmt.Sprintf("invalid json at offset %d: %s", s.pos, message)}
+}
+
+func (s *jsonScanner) expect(c byte) error {
+	s.space()
+	if s.pos >= len(s.raw) || s.raw[s.pos] != c {
+		return s.fail("expected '" + string(c) + "'")
+	}
+	s.pos++
+	return nil
+}
+
+// Skips a value, returning where it starts and ends
+func (s *jsonScanner) value() (int, int, error) {
+	s.space()
+	start := s.pos
+	if s.pos >= len(s.raw) {
+		return 0, 0, s.fail("expected a value")
+	}
+	switch s.raw[s.pos] {
+	case '"':
+		_, err := s.str()
+		return start, s.pos, err
+	case '{', '[':
+		depth := 0
+		for s.pos < len(s.raw) {
+			switch s.raw[s.pos] {
+			case '"':
+				if _, err := s.str(); err != nil {
+					return 0, 0, err
+				}
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+			s.pos++
+			if depth == 0 {
+				return start, s.pos, nil
+			}
+		}
+		return 0, 0, s.fail("unterminated value")
+	}
+	for s.pos < len(s.raw) && strings.IndexByte(",}] \t\r\n", s.raw[s.pos]) < 0 {
+		s.pos++
+	}
+	return start, s.pos, nil
+}
+
+// Scans a string, returning its decoded value
+func (s *jsonScanner) str() (string, error) {
+	start := s.pos
+	for s.pos++; s.pos < len(s.raw); s.pos++ {
+		switch s.raw[s.pos] {
+		case '\\':
+			s.pos++
+		case '"':
+			s.pos++
+			var result string
+			err := json.Unmarshal(s.raw[start:s.pos], &result)
+			return result, err
+		}
+	}
+	return "", s.fail("unterminated string")
+}
+
+// The indentation unit of a json document: that of its first indented line
+func jsonIndentUnit(raw []byte) string {
+	for _, line := range strings.Split(string(raw), "\n") {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if indent != "" && strings.TrimSpace(line) != "" {
+			return indent
+		}
+	}
+	return "    "
+}
+
+func editJSON(raw []byte, path string, steps []interface{}, value interface{}) ([]byte, error) {
+	unit := jsonIndentUnit(raw)
+	encode := func(value interface{}, indent string) (string, error) {
+		encoded, err := json.MarshalIndent(value, indent, unit)
+		return string(encoded), err
+	}
+
+	s := &jsonScanner{raw: raw}
+	s.space()
+	for i, step := range steps {
+		last := i == len(steps)-1
+		s.space()
+		if s.pos >= len(raw) {
+			return nil, s.fail("expected a value")
+		}
+
+		switch step := step.(type) {
+		case string:
+			if raw[s.pos] != '{' {
+				return nil, &CustomError{"config path '" + path + "' does not lead through objects"}
+			}
+			open := s.pos
+			s.pos++
+			found := false
+			members := 0
+			lastEnd := -1
+			firstKey := -1
+			for {
+				s.space()
+				if s.pos < len(raw) && raw[s.pos] == '}' {
+					break
+				}
+				if members > 0 {
+					if err := s.expect(','); err != nil {
+						return nil, err
+					}
+					s.space()
+				}
+				if firstKey < 0 {
+					firstKey = s.pos
+				}
+				if s.pos >= len(raw) || raw[s.pos] != '"' {
+					return nil, s.fail("expected a key")
+				}
+				key, err := s.str()
+				if err != nil {
+					return nil, err
+				}
+				if err := s.expect(':'); err != nil {
+					return nil, err
+				}
+				members++
+				if key == step {
+					found = true
+					break
+				}
+				_, end, err := s.value()
+				if err != nil {
+					return nil, err
+				}
+				lastEnd = end
+			}
+
+			if found && last {
+				start, end, err := s.value()
+				if err != nil {
+					return nil, err
+				}
+				encoded, err := encode(value, lineIndent(raw, start))
+				if err != nil {
+					return nil, err
+				}
+				return splice(raw, start, end, encoded), nil
+			}
+			if found {
+				continue
+			}
+
+			// the key is missing: add it as the last member of the object
+			nested, err := nestValue(path, steps[i+1:], value)
+			if err != nil {
+				return nil, err
+			}
+			close := s.pos
+			multiline := strings.Contains(string(raw[open:close]), "\n")
+			switch {
+			case members > 0 && multiline:
+				indent := lineIndent(raw, firstKey)
+				encoded, err := encode(nested, indent)
+				if err != nil {
+					return nil, err
+				}
+				return splice(raw, lastEnd, lastEnd, ",\n"+indent+strconv.Quote(step)+": "+encoded), nil
+			case members > 0:
+				encoded, err := json.Marshal(nested)
+				if err != nil {
+					return nil, err
+				}
+				return splice(raw, lastEnd, lastEnd, ", "+strconv.Quote(step)+": "+string(encoded)), nil
+			default:
+				indent := lineIndent(raw, open)
+				encoded, err := encode(nested, indent+unit)
+				if err != nil {
+					return nil, err
+				}
+				return splice(raw, open+1, close, "\n"+indent+unit+strconv.Quote(step)+": "+encoded+"\n"+indent), nil
+			}
+
+		case int:
+			if raw[s.pos] != '[' {
+				return nil, &CustomError{"config path '" + path + "' indexes something which is not a list"}
+			}
+			s.pos++
+			for n := 0; ; n++ {
+				s.space()
+				if s.pos < len(raw) && raw[s.pos] == ']' {
+					return nil, &CustomError{"config path '" + path + "' indexes past a list"}
+				}
+				if n > 0 {
+					if err := s.expect(','); err != nil {
+						return nil, err
+					}
+				}
+				if n == step {
+					break
+				}
+				if _, _, err := s.value(); err != nil {
+					return nil, err
+				}
+			}
+			if last {
+				start, end, err := s.value()
+				if err != nil {
+					return nil, err
+				}
+				encoded, err := encode(value, lineIndent(raw, start))
+				if err != nil {
+					return nil, err
+				}
+				return splice(raw, start, end, encoded), nil
+			}
+		}
+	}
+	return nil, &CustomError{"config path '" + path + "' is empty"}
+}
+
+// Replaces raw[start:end] with text
+func splice(raw []byte, start, end int, text string) []byte {
+	out := make([]byte, 0, len(raw)+len(text))
+	out = append(out, raw[:start]...)
+	out = append(out, text...)
+	return append(out, raw[end:]...)
+}
+
+// Matches a YAML mapping entry, capturing its indentation, key and the rest
+// of the line
+var yamlEntry = regexp.MustCompile(`^(\s*)("[^"]*"|'[^']*'|[^\s#'"][^:#]*?)\s*:(\s.*|)$`)
+
+func editYAML(raw []byte, path string, steps []interface{}, value interface{}) ([]byte, error) {
+	lines := strings.Split(string(raw), "\n")
+	parent := -1
+	start, end := 0, len(lines)
+
+	for i, step := range steps {
+		key, ok := step.(string)
+		if !ok {
+			return nil, &CustomError{"config path '" + path + "': lists in yaml files cannot be edited in place"}
+		}
+		last := i == len(steps)-1
+
+		found := -1
+		indent := -1
+		lastContent := start - 1
+		for j := start; j < end; j++ {
+			trimmed := strings.TrimSpace(lines[j])
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+				continue
+			}
+			lastContent = j
+			match := yamlEntry.FindStringSubmatch(lines[j])
+			if match == nil || len(match[1]) <= parent {
+				continue
+			}
+			if indent < 0 {
+				indent = len(match[1])
+			}
+			if len(match[1]) == indent && found < 0 && strings.Trim(match[2], `"'`) == key {
+				found = j
+			}
+		}
+
+		if found < 0 {
+			nested, err := nestValue(path, steps[i+1:], value)
+			if err != nil {
+				return nil, err
+			}
+			if indent < 0 {
+				indent = parent + 1
+				if parent >= 0 {
+					indent = parent + 2
+				}
+			}
+			line := strings.Repeat(" ", indent) + yamlValue(key) + ": " + yamlValue(nested)
+			lines = append(lines[:lastContent+1], append([]string{line}, lines[lastContent+1:]...)...)
+			return []byte(strings.Join(lines, "\n")), nil
+		}
+
+		match := yamlEntry.FindStringSubmatch(lines[found])
+		blockEnd := yamlBlockEnd(lines, found, len(match[1]))
+		rest, comment := splitComment(strings.TrimSpace(match[3]))
+		if last {
+			line := match[1] + match[2] + ": " + yamlValue(value)
+			if comment != "" {
+				line += " " + comment
+			}
+			lines = append(append(lines[:found], line), lines[blockEnd:]...)
+			return []byte(strings.Join(lines, "\n")), nil
+		}
+		if rest != "" {
+			return nil, &CustomError{"config path '" + path + "': '" + key + "' is not a block mapping and cannot be edited in place"}
+		}
+		parent = len(match[1])
+		start, end = found+1, blockEnd
+	}
+	return nil, &CustomError{"config path '" + path + "' is empty"}
+}
+
+// Returns the line after the nested block of the entry at line i, not
+// counting the blank lines and comments following it
+func yamlBlockEnd(lines []string, i, indent int) int {
+	end := i + 1
+	for j := i + 1; j < len(lines); j++ {
+		trimmed := strings.TrimSpace(lines[j])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if len(lines[j])-len(strings.TrimLeft(lines[j], " ")) <= indent {
+			break
+		}
+		end = j + 1
+	}
+	return end
+}
+
+// Splits a trailing comment from the value of a line
+func splitComment(rest string) (string, string) {
+	var quote rune
+	for i, c := range rest {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || rest[i-1] == ' '):
+			return strings.TrimSpace(rest[:i]), rest[i:]
+		}
+	}
+	return rest, ""
+}
+
+// Matches strings which YAML reads back as the same plain string
+var yamlPlain = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ./-]*$`)
+
+// Encodes a value for a YAML file. Strings are written plain when YAML
+// would read them back unchanged, anything else as json, which is valid
+// YAML flow syntax.
+func yamlValue(value interface{}) string {
+	if s, ok := value.(string); ok && yamlPlain.MatchString(s) && strings.TrimSpace(s) == s {
+		switch strings.ToLower(s) {
+		case "true", "false", "yes", "no", "on", "off", "null", "y", "n":
+		default:
+			return s
+		}
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// Matches a TOML table header, capturing its name
+var tomlHeader = regexp.MustCompile(`^\s*\[([^\[\]]+)\]\s*(#.*)?$`)
+
+// Matches a TOML key/value pair, capturing its indentation, key and value
+var tomlEntry = regexp.MustCompile(`^(\s*)("[^"]*"|[A-Za-z0-9_-]+)\s*=\s*(.*)$`)
+
+func editTOML(raw []byte, path string, steps []interface{}, value interface{}) ([]byte, error) {
+	keys := make([]string, len(steps))
+	for i, step := range steps {
+		key, ok := step.(string)
+		if !ok {
+			return nil, &CustomError{"config path '" + path + "': lists in toml files cannot be edited in place"}
+		}
+		keys[i] = key
+	}
+	lines := strings.Split(string(raw), "\n")
+	table := strings.Join(keys[:len(keys)-1], ".")
+	key := keys[len(keys)-1]
+
+	// find the lines of the table holding the key, the root table being
+	// the lines before the first header
+	current := ""
+	start, end := 0, -1
+	if table != "" {
+		start = -1
+	}
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "[[") {
+			if current == table && start >= 0 && end < 0 {
+				end = i
+			}
+			current = "[["
+			continue
+		}
+		match := tomlHeader.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		name := strings.Replace(strings.TrimSpace(match[1]), " ", "", -1)
+		if name == path {
+			return nil, &CustomError{"config path '" + path + "' is a table and cannot be set in place"}
+		}
+		if current == table && start >= 0 && end < 0 {
+			end = i
+		}
+		current = name
+		if name == table {
+			start = i + 1
+		}
+	}
+	if start >= 0 && end < 0 {
+		end = len(lines)
+	}
+
+	encoded, err := tomlValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		// a new table at the end of the file
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, "", "["+table+"]", tomlKey(key)+" = "+encoded, "")
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	lastContent := start - 1
+	for i := start; i < end; i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lastContent = i
+		match := tomlEntry.FindStringSubmatch(lines[i])
+		if match == nil || strings.Trim(match[2], `"`) != key {
+			continue
+		}
+		_, comment := splitComment(match[3])
+		line := match[1] + match[2] + " = " + encoded
+		if comment != "" {
+			line += " " + comment
+		}
+		lines[i] = line
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	line := tomlKey(key) + " = " + encoded
+	lines = append(lines[:lastContent+1], append([]string{line}, lines[lastContent+1:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// Matches the keys TOML allows unquoted
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Encodes a key for a TOML file, quoting it unless it is a bare key
+func tomlKey(key string) string {
+	if tomlBareKey.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+// Encodes a value for a TOML file, objects as inline tables
+func tomlValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		// json string escapes are valid in TOML basic strings
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		if value == float64(int64(value)) {
+			return strconv.FormatInt(int64(value), 10), nil
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			encoded, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = encoded
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			encoded, err := tomlValue(value[key])
+			if err != nil {
+				return "", err
+			}
+			items[i] = tomlKey(key) + " = " + encoded
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+	return "", &CustomError{fmt.Sprintf("%v cannot be written to a toml file", value)}
+}
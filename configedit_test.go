@@ -0,0 +1,362 @@
+package main
+
+import "encoding/json"
+import "reflect"
+import "testing"
+
+// An edit of a config file, and the file it should produce
+type configEdit struct {
+	name  string
+	path  string
+	value interface{}
+	want  string
+	err   bool
+}
+
+func testEdits(t *testing.T, filename, raw string, tests []configEdit) {
+	for _, test := range tests {
+		got, err := EditConfig([]byte(raw), filename, test.path, test.value)
+		if (err != nil) != test.err {
+			t.Errorf("%s: EditConfig(%q) error = %v, want error %v", test.name, test.path, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: EditConfig(%q) =\n%s\nwant\n%s", test.name, test.path, got, test.want)
+			continue
+		}
+
+		// the edited file must read back as the original with the value set
+		var before, after map[string]interface{}
+		if filename == "config.json" {
+			json.Unmarshal([]byte(raw), &before)
+			err = json.Unmarshal(got, &after)
+		} else {
+			before, _ = decodeConfig([]byte(raw), filename[len("config"):])
+			after, err = decodeConfig(got, filename[len("config"):])
+		}
+		if err != nil {
+			t.Errorf("%s: edited file does not parse: %s", test.name, err)
+			continue
+		}
+		expected := &Config{data: before}
+		if err := expected.SetPath(test.path, test.value); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(after, expected.data) {
+			t.Errorf("%s: edited file reads as %v, want %v", test.name, after, expected.data)
+		}
+	}
+}
+
+func TestEditJSON(t *testing.T) {
+	raw := `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "a"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {}
+}
+`
+	testEdits(t, "config.json", raw, []configEdit{
+		{"replace", "title", "Nouveau titre – été", `{
+    "title": "Nouveau titre – été",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "a"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {}
+}
+`, false},
+		{"replace nested", "social.twitter", "b", `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "b"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {}
+}
+`, false},
+		{"add to object", "social.github", "gh", `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "a",
+        "github": "gh"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {}
+}
+`, false},
+		{"create nested objects", "params.colors.accent", "#f00", `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "a"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {},
+    "params": {
+        "colors": {
+            "accent": "#f00"
+        }
+    }
+}
+`, false},
+		{"add to empty object", "empty.x", float64(1), `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "a"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {
+        "x": 1
+    }
+}
+`, false},
+		{"list index", "widths[1]", float64(800), `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "a"
+    },
+    "widths": [320, 800],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {}
+}
+`, false},
+		{"inline object in a list", "menus.main[0].title", "Home", `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "twitter": "a"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/", "title": "Home"}]},
+    "empty": {}
+}
+`, false},
+		{"replace object", "social", map[string]interface{}{"x": "y"}, `{
+    "title": "Old",
+    "a.b": "dotted",
+    "social": {
+        "x": "y"
+    },
+    "widths": [320, 640],
+    "menus": {"main": [{"url": "/"}]},
+    "empty": {}
+}
+`, false},
+		{"index past a list", "widths[2]", float64(1), "", true},
+		{"through a string", "title.x", "y", "", true},
+		{"missing list", "missing[0]", "y", "", true},
+	})
+}
+
+func TestEditYAML(t *testing.T) {
+	raw := `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: a
+  deep:
+    x: 1
+
+url: http://example.com
+inline: {a: b}
+`
+	testEdits(t, "config.yaml", raw, []configEdit{
+		{"replace keeping comments", "title", "New Site", `# site settings
+title: New Site # the title
+"quoted key": x
+social:
+  twitter: a
+  deep:
+    x: 1
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"quoted key", "quoted key", "z", `# site settings
+title: Old # the title
+"quoted key": z
+social:
+  twitter: a
+  deep:
+    x: 1
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"strings yaml would not read back", "social.twitter", "true", `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: "true"
+  deep:
+    x: 1
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"non-ascii", "social.twitter", "@été #1", `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: "@été #1"
+  deep:
+    x: 1
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"add to block", "social.github", "gh", `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: a
+  deep:
+    x: 1
+  github: gh
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"add deeper", "social.deep.z", float64(2), `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: a
+  deep:
+    x: 1
+    z: 2
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"replace block", "social.deep", []interface{}{"a", "b"}, `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: a
+  deep: ["a","b"]
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"create nested objects", "params.colors", map[string]interface{}{"accent": "red"}, `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: a
+  deep:
+    x: 1
+
+url: http://example.com
+inline: {a: b}
+params: {"colors":{"accent":"red"}}
+`, false},
+		{"keys yaml would not read back", "social.y", "yes", `# site settings
+title: Old # the title
+"quoted key": x
+social:
+  twitter: a
+  deep:
+    x: 1
+  "y": "yes"
+
+url: http://example.com
+inline: {a: b}
+`, false},
+		{"list index", "widths[0]", float64(1), "", true},
+		{"inline mapping", "inline.a", "c", "", true},
+	})
+}
+
+func TestEditTOML(t *testing.T) {
+	raw := `title = "Old" # the title
+
+[social]
+twitter = "a"
+"quoted key" = 1
+
+[[menus]]
+url = "/"
+`
+	testEdits(t, "config.toml", raw, []configEdit{
+		{"replace keeping comments", "title", "Été", `title = "Été" # the title
+
+[social]
+twitter = "a"
+"quoted key" = 1
+
+[[menus]]
+url = "/"
+`, false},
+		{"add to root table", "url", "http://example.com", `title = "Old" # the title
+url = "http://example.com"
+
+[social]
+twitter = "a"
+"quoted key" = 1
+
+[[menus]]
+url = "/"
+`, false},
+		{"replace in table", "social.twitter", "b", `title = "Old" # the title
+
+[social]
+twitter = "b"
+"quoted key" = 1
+
+[[menus]]
+url = "/"
+`, false},
+		{"quoted key", "social.quoted key", float64(2.5), `title = "Old" # the title
+
+[social]
+twitter = "a"
+"quoted key" = 2.5
+
+[[menus]]
+url = "/"
+`, false},
+		{"add to table", "social.github", "gh", `title = "Old" # the title
+
+[social]
+twitter = "a"
+"quoted key" = 1
+github = "gh"
+
+[[menus]]
+url = "/"
+`, false},
+		{"create table", "params.colors", map[string]interface{}{"accent": "red", "a b": true}, `title = "Old" # the title
+
+[social]
+twitter = "a"
+"quoted key" = 1
+
+[[menus]]
+url = "/"
+
+[params]
+colors = { "a b" = true, accent = "red" }
+`, false},
+		{"replace table", "social", "x", "", true},
+		{"list index", "menus[0].url", "/home/", "", true},
+	})
+}
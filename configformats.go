@@ -0,0 +1,139 @@
+package main
+
+import "fmt"
+import "path/filepath"
+import "strings"
+import "time"
+
+import "github.com/BurntSushi/toml"
+import "gopkg.in/yaml.v2"
+
+// The names a workspace config file may have. A workspace must have exactly
+// one of them.
+var ConfigNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// Returns the config file of a workspace directory. It is an error for
+// the workspace to have none or several.
+func FindConfig(dir string) (string, error) {
+	found := make([]string, 0)
+	for _, name := range ConfigNames {
+		if Exists(filepath.Join(dir, name)) {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", &CustomError{"no config file in '" + dir + "' (expected one of " + strings.Join(ConfigNames, ", ") + ")"}
+	case 1:
+		return filepath.Join(dir, found[0]), nil
+	}
+	return "", &CustomError{"several config files in '" + dir + "': " + strings.Join(found, ", ") + "; keep only one"}
+}
+
+// Whether a file name, relative to its workspace, is the name of a config
+// file or of the overlay of an environment
+func isConfigName(name string) bool {
+	for _, configname := range ConfigNames {
+		ext := filepath.Ext(configname)
+		if name == configname || strings.HasPrefix(name, "config.") && strings.HasSuffix(name, ext) && len(name) > len(configname)+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Decodes a YAML or TOML config into the values JSON decodes to, so that
+// configs behave the same whatever their format
+func decodeConfig(raw []byte, ext string) (map[string]interface{}, error) {
+	var data interface{}
+	switch strings.ToLower(ext) {
+	case ".toml":
+		var table map[string]interface{}
+		_, err := toml.Decode(string(raw), &table)
+		if err != nil {
+			return nil, err
+		}
+		data = table
+	default:
+		err := yaml.Unmarshal(raw, &data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if data == nil {
+		return make(map[string]interface{}), nil
+	}
+	value, err := normalizeValue(data)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, &CustomError{"the config must be an object of keys and values"}
+	}
+	return object, nil
+}
+
+// Converts a decoded YAML or TOML value into the JSON equivalent: objects
+// with string keys, []interface{} lists and float64 numbers
+func normalizeValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			normalized, err := normalizeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			object[fmt.Sprint(key)] = normalized
+		}
+		return object, nil
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			normalized, err := normalizeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = normalized
+		}
+		return object, nil
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			normalized, err := normalizeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = normalized
+		}
+		return list, nil
+	case []map[string]interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			normalized, err := normalizeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = normalized
+		}
+		return list, nil
+	case int:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	case uint64:
+		return float64(value), nil
+	case float32:
+		return float64(value), nil
+	case time.Time:
+		return value.Format(time.RFC3339), nil
+	case nil, bool, string, float64:
+		return value, nil
+	case fmt.Stringer:
+		// e.g. the local dates and times of TOML
+		return value.String(), nil
+	}
+	return nil, &CustomError{fmt.Sprintf("unsupported config value %v", value)}
+}
@@ -17,15 +17,15 @@ func CopyFile(source string, dest string) (err error) {
     }
     defer df.Close()
     _, err = io.Copy(df, sf)
-    if err == nil {
-        si, err := os.Stat(source)
-        if err != nil {
-            err = os.Chmod(dest, si.Mode())
-        }
-
+    if err != nil {
+        return err
     }
 
-    return
+    si, err := os.Stat(source)
+    if err != nil {
+        return err
+    }
+    return os.Chmod(dest, si.Mode().Perm())
 }
 
 // Recursively copies a directory tree, attempting to preserve permissions. 
@@ -0,0 +1,252 @@
+package main
+
+import "os"
+import "path/filepath"
+
+// The default theme written into new workspaces by init. Keys are paths
+// relative to the theme directory.
+var DefaultTheme = map[string]string{
+	"theme.json":           DefaultThemeJSON,
+	"partials/head.html":   DefaultThemeHead,
+	"partials/header.html": DefaultThemeHeader,
+	"partials/footer.html": DefaultThemeFooter,
+	"page.html":            DefaultThemePage,
+	"post.html":            DefaultThemePost,
+	"list.html":            DefaultThemeList,
+	"taxonomy.html":        DefaultThemeTaxonomy,
+	"404.html":             DefaultTheme404,
+	"static/css/style.css": DefaultThemeCSS,
+}
+
+// Writes the default theme into the directory dir
+func WriteDefaultTheme(dir string) error {
+	for name, contents := range DefaultTheme {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = CreateSimpleFile(path, contents, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const DefaultThemeJSON = `{
+    "name": "default",
+    "description": "The goblin default theme",
+    "version": "1.0",
+    "params": {
+        "accent_color": {"type": "string", "default": "#2a6db0"},
+        "logo": {"type": "string", "default": ""},
+        "show_powered_by": {"type": "bool", "default": true}
+    }
+}
+`
+
+const DefaultThemeHead = `<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="author" content="{{ site_author }}">
+<link rel="stylesheet" href="{{ "css/style.css"|asset }}" integrity="{{ "css/style.css"|asset_sri }}">
+<style>:root { --accent: {{ theme.params.accent_color }}; }</style>
+`
+
+const DefaultThemeHeader = `<header class="site-header">
+    <div class="wrap">
+        <a class="site-title" href="{{ "/"|relurl }}">
+            {% if theme.params.logo %}<img src="{{ theme.params.logo|relurl }}" alt="{{ site_title }}">{% else %}{{ site_title }}{% endif %}
+        </a>
+        <nav class="site-nav">
+            {% for p in site_pages|where:"mainnav"|sort_by:"order" %}
+            <a href="{{ p.url|relurl }}">{{ p.title }}</a>
+            {% endfor %}
+        </nav>
+    </div>
+</header>
+`
+
+const DefaultThemeFooter = `<footer class="site-footer">
+    <div class="wrap">
+        <p>{{ site_copyright }}</p>
+        {% if theme.params.show_powered_by %}<p class="powered">{{ goblin_powered|safe }}</p>{% endif %}
+    </div>
+</footer>
+`
+
+const DefaultThemePage = `<!DOCTYPE html>
+<html>
+<head>
+    {% include "head.html" %}
+    <title>{{ page_title }} | {{ site_title }}</title>
+</head>
+<body>
+    {% include "header.html" %}
+    <main class="wrap">
+        <article class="page">
+            {{ content|safe }}
+        </article>
+    </main>
+    {% include "footer.html" %}
+</body>
+</html>
+`
+
+const DefaultThemePost = `<!DOCTYPE html>
+<html>
+<head>
+    {% include "head.html" %}
+    <title>{{ page_title }} | {{ site_title }}</title>
+</head>
+<body>
+    {% include "header.html" %}
+    <main class="wrap">
+        <article class="post">
+            <header class="post-header">
+                <h1>{{ page_title }}</h1>
+                <p class="post-meta">{{ page_date|date:"January 2, 2006" }} {{ page_author }}</p>
+            </header>
+            {{ content|safe }}
+        </article>
+    </main>
+    {% include "footer.html" %}
+</body>
+</html>
+`
+
+const DefaultThemeList = `<!DOCTYPE html>
+<html>
+<head>
+    {% include "head.html" %}
+    <title>{{ page_title }} | {{ site_title }}</title>
+</head>
+<body>
+    {% include "header.html" %}
+    <main class="wrap">
+        {{ content|safe }}
+        <ul class="list">
+            {% for p in site_pages|sort_by:"-date" %}
+            <li>
+                <a href="{{ p.url|relurl }}">{{ p.title }}</a>
+                <span class="post-meta">{{ p.date|date:"January 2, 2006" }}</span>
+            </li>
+            {% endfor %}
+        </ul>
+    </main>
+    {% include "footer.html" %}
+</body>
+</html>
+`
+
+const DefaultThemeTaxonomy = `<!DOCTYPE html>
+<html>
+<head>
+    {% include "head.html" %}
+    <title>{{ page_title }} | {{ site_title }}</title>
+</head>
+<body>
+    {% include "header.html" %}
+    <main class="wrap">
+        <h1>{{ page_title }}</h1>
+        {{ content|safe }}
+        <ul class="list">
+            {% for p in site_pages|sort_by:"title" %}
+            <li><a href="{{ p.url|relurl }}">{{ p.title }}</a></li>
+            {% endfor %}
+        </ul>
+    </main>
+    {% include "footer.html" %}
+</body>
+</html>
+`
+
+const DefaultTheme404 = `<!DOCTYPE html>
+<html>
+<head>
+    {% include "head.html" %}
+    <title>Page Not Found | {{ site_title }}</title>
+</head>
+<body>
+    {% include "header.html" %}
+    <main class="wrap">
+        <h1>Page Not Found</h1>
+        <p>Sorry, the page you were looking for does not exist.</p>
+        <p><a href="{{ "/"|relurl }}">Return home</a></p>
+    </main>
+    {% include "footer.html" %}
+</body>
+</html>
+`
+
+const DefaultThemeCSS = `*, *::before, *::after { box-sizing: border-box; }
+
+html { font-size: 100%; -webkit-text-size-adjust: 100%; }
+
+body {
+    margin: 0;
+    color: #222;
+    background: #fff;
+    font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif;
+    line-height: 1.6;
+}
+
+a { color: var(--accent, #2a6db0); }
+a:hover { color: #174a7c; }
+
+img { max-width: 100%; height: auto; }
+
+pre {
+    overflow-x: auto;
+    padding: 1em;
+    background: #f5f5f5;
+}
+
+.wrap {
+    max-width: 48rem;
+    margin: 0 auto;
+    padding: 0 1rem;
+}
+
+.site-header {
+    border-bottom: 1px solid #e5e5e5;
+    padding: 1rem 0;
+}
+
+.site-header .wrap {
+    display: flex;
+    flex-wrap: wrap;
+    align-items: baseline;
+    justify-content: space-between;
+}
+
+.site-title img { max-height: 2.5rem; vertical-align: middle; }
+
+.site-title {
+    color: #222;
+    font-size: 1.5rem;
+    font-weight: bold;
+    text-decoration: none;
+}
+
+.site-nav a { margin-left: 1rem; }
+
+main { padding: 2rem 0; }
+
+.post-meta { color: #777; font-size: 0.875rem; }
+
+.list { list-style: none; padding: 0; }
+.list li { margin-bottom: 0.5rem; }
+
+.site-footer {
+    border-top: 1px solid #e5e5e5;
+    color: #777;
+    font-size: 0.875rem;
+    padding: 1rem 0;
+}
+
+@media (max-width: 30rem) {
+    .site-header .wrap { display: block; }
+    .site-nav a { margin: 0 1rem 0 0; }
+}
+`
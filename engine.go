@@ -0,0 +1,152 @@
+package main
+
+import "bytes"
+import "fmt"
+import "html/template"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+
+import "github.com/flosch/pongo"
+
+// The values passed to a layout when it is rendered
+type TemplateContext map[string]interface{}
+
+// A template engine renders the layouts of a site. Themes choose their
+// engine with the 'engine' key of their theme.json.
+type TemplateEngine interface {
+	Render(layout string, context TemplateContext) (string, error)
+}
+
+// Creates the template engine declared by the site theme. A theme which does
+// not declare an engine uses the engine of its parent, or pongo if none of
+// its parents declare one either.
+func NewTemplateEngine(m *Manager) (TemplateEngine, error) {
+	engine := ""
+	for _, theme := range m.Theme.Chain() {
+		if engine = theme.Config.GetString("engine"); engine != "" {
+			break
+		}
+	}
+
+	switch engine {
+	case "", "pongo":
+		RegisterFilters(m)
+		return &pongoEngine{m}, nil
+	case "gotemplate":
+		return newGoTemplateEngine(m)
+	}
+	return nil, &CustomError{"theme '" + m.Theme.Name + "' uses unknown template engine '" + engine + "'"}
+}
+
+// Renders pongo layouts, resolving includes through the partial directories
+type pongoEngine struct {
+	manager *Manager
+}
+
+func (e *pongoEngine) Render(layout string, context TemplateContext) (string, error) {
+	path, ok := e.manager.FindLayout(layout)
+	if !ok {
+		return "", &CustomError{"layout '" + layout + "' not found in workspace or theme '" + e.manager.Theme.Name + "'"}
+	}
+
+	tpl, err := pongo.FromFile(path, TemplateLocator(e.manager.PartialDirs()))
+	if err != nil {
+		return "", err
+	}
+
+	pongocontext := pongo.Context(context)
+	out, err := tpl.Execute(&pongocontext)
+	if err != nil {
+		return "", err
+	}
+	return *out, nil
+}
+
+// Renders html/template layouts. Every template in the workspace and theme
+// 'partials' directories is available to layouts by its relative path, e.g.
+// {{ template "header.html" . }}, and the goblin filters are available as
+// functions which take the piped value last.
+type goTemplateEngine struct {
+	manager  *Manager
+	partials *template.Template
+}
+
+func newGoTemplateEngine(m *Manager) (*goTemplateEngine, error) {
+	funcs := template.FuncMap{
+		"safe": func(value interface{}) template.HTML {
+			return template.HTML(fmt.Sprint(value))
+		},
+	}
+	for name, filter := range TemplateFilters(m) {
+		funcs[name] = goTemplateFunc(filter)
+	}
+	partials := template.New("").Funcs(funcs)
+
+	// parse the partials of the lowest precedence first so that the
+	// partials of child themes and the workspace replace them
+	dirs := []string{filepath.Join(m.Fspath, "partials")}
+	for _, theme := range m.Theme.Chain() {
+		dirs = append(dirs, filepath.Join(theme.Path, "partials"))
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if !Exists(dirs[i]) {
+			continue
+		}
+		dir := dirs[i]
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() || filepath.Ext(path) != ".html" {
+				return err
+			}
+			raw, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, path)
+			_, err = partials.New(filepath.ToSlash(rel)).Parse(string(raw))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &goTemplateEngine{m, partials}, nil
+}
+
+func (e *goTemplateEngine) Render(layout string, context TemplateContext) (string, error) {
+	path, ok := e.manager.FindLayout(layout)
+	if !ok {
+		return "", &CustomError{"layout '" + layout + "' not found in workspace or theme '" + e.manager.Theme.Name + "'"}
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	tpl, err := e.partials.Clone()
+	if err != nil {
+		return "", err
+	}
+	tpl, err = tpl.New(layout + ".html").Parse(string(raw))
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	err = tpl.Execute(&out, map[string]interface{}(context))
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Adapts a pongo filter to a template function. The piped value is passed
+// to template functions as their last argument.
+func goTemplateFunc(filter pongo.FilterFunc) func(...interface{}) (interface{}, error) {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, &CustomError{"missing value"}
+		}
+		return filter(args[len(args)-1], args[:len(args)-1], nil)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import "encoding/json"
+import "os"
+import "path/filepath"
+import "reflect"
+import "strings"
+
+// The prefix of the environment variables overriding config keys
+const EnvPrefix = "GOBLIN_"
+
+// Returns the overlay config file of an environment in a workspace, such as
+// 'config.production.yaml', or "" if it has none. Like the base config, an
+// environment may have a single overlay file.
+func FindOverlay(dir, env string) (string, error) {
+	if env == "" || strings.ContainsAny(env, `/\.`) {
+		return "", nil
+	}
+	found := make([]string, 0)
+	for _, name := range ConfigNames {
+		ext := filepath.Ext(name)
+		overlay := strings.TrimSuffix(name, ext) + "." + env + ext
+		if Exists(filepath.Join(dir, overlay)) {
+			found = append(found, overlay)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return filepath.Join(dir, found[0]), nil
+	}
+	return "", &CustomError{"several config files for environment '" + env + "' in '" + dir + "': " + strings.Join(found, ", ") + "; keep only one"}
+}
+
+// Overrides config keys with the GOBLIN_* variables of an environment.
+// The rest of a variable name, lowercased, is the key it overrides, with
+// '__' separating nested keys: GOBLIN_SOCIAL__TWITTER sets social.twitter.
+// Values of keys which are set or known to the site config are decoded as
+// JSON, so that numbers, bools and lists keep their type, unless the key
+// holds a string; other values are kept as strings. Variables naming paths
+// which cannot be set are skipped with a warning.
+func (c *Config) ApplyEnvironment(environ []string) []string {
+	warnings := make([]string, 0)
+	fields := siteConfigFields()
+	for _, variable := range environ {
+		if !strings.HasPrefix(variable, EnvPrefix) {
+			continue
+		}
+		equals := strings.Index(variable, "=")
+		if equals < 0 {
+			continue
+		}
+		name, raw := variable[len(EnvPrefix):equals], variable[equals+1:]
+		if name == "" {
+			continue
+		}
+		path := strings.Replace(strings.ToLower(name), "__", ".", -1)
+
+		var value interface{} = raw
+		_, known := fields[path]
+		if c.Has(path) || known {
+			value = c.decodeValue(path, raw)
+		}
+		err := c.SetPath(path, value)
+		if err != nil {
+			warnings = append(warnings, "ignoring "+EnvPrefix+name+": "+err.Error())
+		}
+	}
+	return warnings
+}
+
+// Decodes a value given as text for a config key as JSON, so that numbers,
+// bools and lists keep their type, unless the key holds a string
+func (c *Config) decodeValue(path, raw string) interface{} {
+	_, isString := c.LookupString(path)
+	if index, ok := siteConfigFields()[path]; ok {
+		isString = reflect.TypeOf(SiteConfig{}).Field(index).Type.Kind() == reflect.String
+	}
+	if !isString {
+		var decoded interface{}
+		if json.Unmarshal([]byte(raw), &decoded) == nil {
+			return decoded
+		}
+	}
+	return raw
+}
+
+// Loads the config of a workspace for an environment: the base config file,
+// merged with the overlay of the environment and then the GOBLIN_*
+// environment variables. Returns warnings about variables which were not
+// applied.
+func LoadEnvConfig(dir, env string) (*Config, []string, error) {
+	path, err := FindConfig(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	overlay, err := FindOverlay(dir, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	if overlay != "" {
+		other, err := LoadConfig(overlay)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.Merge(other)
+	}
+
+	return config, config.ApplyEnvironment(os.Environ()), nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "reflect"
+import "testing"
+
+func TestApplyEnvironment(t *testing.T) {
+	config, err := LoadConfigString(`{"title": "Site", "image_quality": 80, "social": {"twitter": "a"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := config.ApplyEnvironment([]string{
+		"HOME=/root",
+		"GOBLIN_IMAGE_QUALITY=90",
+		"GOBLIN_TITLE=123",
+		"GOBLIN_SOCIAL__TWITTER=b",
+		"GOBLIN_ANALYTICS_ID=12345678901234567890",
+		"GOBLIN_TITLE__X=1",
+		"GOBLIN_IMAGE_WIDTHS=[320, 640]",
+	})
+
+	want := map[string]interface{}{
+		"title":         "123",
+		"image_quality": float64(90),
+		"social":        map[string]interface{}{"twitter": "b"},
+		"analytics_id":  "12345678901234567890",
+		"image_widths":  []interface{}{float64(320), float64(640)},
+	}
+	if !reflect.DeepEqual(config.data, want) {
+		t.Errorf("config = %v, want %v", config.data, want)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q, want one about GOBLIN_TITLE__X", warnings)
+	}
+}
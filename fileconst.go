@@ -17,3 +17,29 @@ slug: home
 This is the home page...
 
 `
+
+// The built-in archetypes of 'goblin new', used when the workspace has no
+// archetypes/page.md or archetypes/post.md
+const PageMD = `---
+title: {{ title }}
+author: {{ author }}
+date: {{ date }}
+
+layout: {{ layout }}
+slug: {{ slug }}
+---
+
+## {{ title }}
+
+`
+
+const PostMD = `---
+title: {{ title }}
+author: {{ author }}
+date: {{ date }}
+
+layout: {{ layout }}
+slug: {{ slug }}
+---
+
+`
@@ -0,0 +1,379 @@
+package main
+
+import "encoding/json"
+import "fmt"
+import "net/url"
+import "path"
+import "reflect"
+import "regexp"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+import "unicode"
+
+import "github.com/flosch/pongo"
+
+// Layouts accepted for dates in front matter and by the date filter
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// Named layouts which may be given to the date filter instead of a Go layout
+var namedDateLayouts = map[string]string{
+	"rfc3339": time.RFC3339,
+	"rfc1123": time.RFC1123Z,
+	"rfc822":  time.RFC822Z,
+	"iso":     "2006-01-02",
+}
+
+var htmlTokenRegexp = regexp.MustCompile(`<[^>]*>|[^<]+`)
+var htmlTagNameRegexp = regexp.MustCompile(`^</?\s*([a-zA-Z0-9]+)`)
+
+// Elements which never have a closing tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Parses a date in any of the accepted date layouts
+func ParseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, &CustomError{"cannot parse date '" + value + "'"}
+}
+
+// Returns the goblin template filters
+func TemplateFilters(m *Manager) map[string]pongo.FilterFunc {
+	return map[string]pongo.FilterFunc{
+		"date":    filterDate,
+		"slugify": filterSlugify,
+		"absurl": func(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+			return AbsURL(m.Site.Url, fmt.Sprint(value)), nil
+		},
+		"relurl": func(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+			return RelURL(m.Site.Url, fmt.Sprint(value)), nil
+		},
+		"markdownify": func(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+			return RenderMarkdown(fmt.Sprint(value)), nil
+		},
+		"truncatewords_html": filterTruncatewordsHtml,
+		"jsonify":            filterJsonify,
+		"where":              filterWhere,
+		"sort_by":            filterSortBy,
+		"asset": func(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+			if m.Assets == nil {
+				return RelURL(m.Site.Url, path.Join("static", fmt.Sprint(value))), nil
+			}
+			return m.Assets.Lookup(fmt.Sprint(value)).Url, nil
+		},
+		"asset_sri": func(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+			if m.Assets == nil {
+				return "", nil
+			}
+			return m.Assets.Lookup(fmt.Sprint(value)).Integrity, nil
+		},
+	}
+}
+
+// Registers the goblin template filters with pongo. Filters producing html
+// (markdownify, truncatewords_html) must be followed by 'safe' in themes.
+func RegisterFilters(m *Manager) {
+	for name, filter := range TemplateFilters(m) {
+		pongo.Filters[name] = filter
+	}
+}
+
+// Returns the first filter argument as a string, or def if none was given
+func filterArg(args []interface{}, def string) string {
+	if len(args) == 0 || args[0] == nil {
+		return def
+	}
+	return fmt.Sprint(args[0])
+}
+
+// Makes link absolute against the site url
+func AbsURL(siteurl, link string) string {
+	ref, err := url.Parse(link)
+	if err != nil || ref.IsAbs() || siteurl == "" {
+		return link
+	}
+	base, err := url.Parse(strings.TrimSuffix(siteurl, "/") + "/")
+	if err != nil {
+		return link
+	}
+	ref.Path = strings.TrimPrefix(ref.Path, "/")
+	return base.ResolveReference(ref).String()
+}
+
+// Makes link root-relative, prefixed with the path of the site url
+func RelURL(siteurl, link string) string {
+	ref, err := url.Parse(link)
+	if err != nil || ref.IsAbs() {
+		return link
+	}
+	prefix := "/"
+	if base, err := url.Parse(siteurl); err == nil && base.Path != "" {
+		prefix = strings.TrimSuffix(base.Path, "/") + "/"
+	}
+	ref.Path = prefix + strings.TrimPrefix(ref.Path, "/")
+	return ref.String()
+}
+
+// Converts a title into a lowercase, hyphenated url slug
+func Slugify(value string) string {
+	slug := make([]rune, 0, len(value))
+	hyphen := false
+	for _, r := range strings.ToLower(value) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			slug = append(slug, r)
+			hyphen = false
+		} else if !hyphen && len(slug) > 0 {
+			slug = append(slug, '-')
+			hyphen = true
+		}
+	}
+	return strings.TrimSuffix(string(slug), "-")
+}
+
+func filterDate(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case string:
+		if v == "" {
+			return "", nil
+		}
+		var err error
+		t, err = ParseDate(v)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, &CustomError{fmt.Sprintf("date filter cannot format %T", value)}
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+
+	layout := filterArg(args, "January 2, 2006")
+	if named, ok := namedDateLayouts[layout]; ok {
+		layout = named
+	}
+	return t.Format(layout), nil
+}
+
+func filterSlugify(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	return Slugify(fmt.Sprint(value)), nil
+}
+
+// Truncates html to the given number of words, closing any tags left open
+func filterTruncatewordsHtml(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	limit, err := strconv.Atoi(filterArg(args, "0"))
+	if err != nil || limit < 0 {
+		return nil, &CustomError{"truncatewords_html takes a positive number of words"}
+	}
+
+	var out strings.Builder
+	open := make([]string, 0)
+	words := 0
+
+	for _, token := range htmlTokenRegexp.FindAllString(fmt.Sprint(value), -1) {
+		if strings.HasPrefix(token, "<") {
+			match := htmlTagNameRegexp.FindStringSubmatch(token)
+			if match != nil && !strings.HasSuffix(token, "/>") {
+				name := strings.ToLower(match[1])
+				if strings.HasPrefix(token, "</") {
+					for i := len(open) - 1; i >= 0; i-- {
+						if open[i] == name {
+							open = open[:i]
+							break
+						}
+					}
+				} else if !voidElements[name] {
+					open = append(open, name)
+				}
+			}
+			out.WriteString(token)
+			continue
+		}
+
+		fields := strings.Fields(token)
+		if words+len(fields) <= limit {
+			out.WriteString(token)
+			words += len(fields)
+			continue
+		}
+
+		// write the words that still fit, keeping the original spacing
+		end := 0
+		for i := 0; i < limit-words; i++ {
+			end += strings.Index(token[end:], fields[i]) + len(fields[i])
+		}
+		out.WriteString(token[:end])
+		out.WriteString(" ...")
+		break
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+	return out.String(), nil
+}
+
+func filterJsonify(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+// Returns the field key of a map or struct item in a collection
+func itemField(item reflect.Value, key string) (interface{}, bool) {
+	for item.Kind() == reflect.Interface || item.Kind() == reflect.Ptr {
+		if item.IsNil() {
+			return nil, false
+		}
+		item = item.Elem()
+	}
+
+	switch item.Kind() {
+	case reflect.Map:
+		if item.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		v := item.MapIndex(reflect.ValueOf(key))
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	case reflect.Struct:
+		v := item.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) })
+		if !v.IsValid() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+	return nil, false
+}
+
+// Returns the items of a collection as a slice of reflect values
+func collectionItems(value interface{}) ([]reflect.Value, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, &CustomError{fmt.Sprintf("expected a collection, got %T", value)}
+	}
+	items := make([]reflect.Value, v.Len())
+	for i := range items {
+		items[i] = v.Index(i)
+	}
+	return items, nil
+}
+
+// Filters a collection down to the items where key is truthy, or where key
+// equals a value when given as 'key=value'.
+func filterWhere(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	items, err := collectionItems(value)
+	if err != nil {
+		return nil, err
+	}
+
+	key, want := filterArg(args, ""), ""
+	compare := false
+	if i := strings.Index(key, "="); i >= 0 {
+		key, want, compare = key[:i], key[i+1:], true
+	}
+
+	result := make([]interface{}, 0)
+	for _, item := range items {
+		field, ok := itemField(item, key)
+		if !ok {
+			continue
+		}
+		if compare && fmt.Sprint(field) != want {
+			continue
+		}
+		if !compare && !truthy(field) {
+			continue
+		}
+		result = append(result, item.Interface())
+	}
+	return result, nil
+}
+
+// Sorts a collection by the field key, descending when given as '-key'
+func filterSortBy(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	items, err := collectionItems(value)
+	if err != nil {
+		return nil, err
+	}
+
+	key := filterArg(args, "")
+	descending := strings.HasPrefix(key, "-")
+	key = strings.TrimPrefix(key, "-")
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, _ := itemField(items[i], key)
+		b, _ := itemField(items[j], key)
+		if descending {
+			return lessValue(b, a)
+		}
+		return lessValue(a, b)
+	})
+
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		result[i] = item.Interface()
+	}
+	return result, nil
+}
+
+// Whether a template value is considered true
+func truthy(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() > 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() != 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() != 0
+	}
+	return true
+}
+
+// Orders two template values of the same kind, comparing anything else as
+// strings.
+func lessValue(a, b interface{}) bool {
+	switch av := a.(type) {
+	case int:
+		if bv, ok := b.(int); ok {
+			return av < bv
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return av < bv
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return av.Before(bv)
+		}
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
@@ -0,0 +1,178 @@
+package main
+
+import "bytes"
+import "fmt"
+import "net/http"
+import "path/filepath"
+import "sort"
+import "strings"
+
+// Headers sent with the responses for the paths matching a pattern. A '*'
+// in the pattern matches any run of characters, slashes included.
+type HeaderRule struct {
+	Path    string
+	Headers http.Header
+}
+
+// The header rules of a site, in the order they are applied
+type HeaderRules []HeaderRule
+
+// Returns the header rules of the site. The server_headers config applies
+// to every path, as a '/*' rule; the headers config maps path patterns to
+// the headers of their responses, e.g.
+//
+//	"headers": {"/static/*": {"Cache-Control": "max-age=31536000"}}
+//
+// A header may be given a list of values, or an empty string to not send
+// it. Rules are applied from the least to the most specific pattern, so
+// that the latter take precedence: patterns with a '*' before exact paths,
+// and among those the one with fewer other characters first. The headers
+// config takes precedence over server_headers for the same pattern.
+func (m *Manager) HeaderRules() (HeaderRules, error) {
+	rules := make(HeaderRules, 0)
+	if len(m.Site.ServerHeaders) > 0 {
+		rule := HeaderRule{Path: "/*", Headers: make(http.Header)}
+		for name, value := range m.Site.ServerHeaders {
+			rule.Headers.Set(name, value)
+		}
+		rules = append(rules, rule)
+	}
+
+	patterns := make([]string, 0, len(m.Site.Headers))
+	for pattern := range m.Site.Headers {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		fields := m.Site.Headers[pattern]
+		if !strings.HasPrefix(pattern, "/") {
+			return nil, &CustomError{"config key 'headers': '" + pattern + "' is not a root-relative path"}
+		}
+		rule := HeaderRule{Path: pattern, Headers: make(http.Header)}
+		for name, values := range fields {
+			switch values := values.(type) {
+			case string:
+				rule.Headers.Add(name, values)
+			case []interface{}:
+				for _, value := range values {
+					s, ok := value.(string)
+					if !ok {
+						return nil, &CustomError{"config key 'headers." + pattern + "." + name + "' must be a string or a list of strings"}
+					}
+					rule.Headers.Add(name, s)
+				}
+			default:
+				return nil, &CustomError{"config key 'headers." + pattern + "." + name + "' must be a string or a list of strings"}
+			}
+		}
+		rules = append(rules, rule)
+	}
+	rules.sort()
+	return rules, nil
+}
+
+// Sorts rules from the least to the most specific pattern, keeping the
+// order of rules with the same pattern
+func (rules HeaderRules) sort() {
+	sort.SliceStable(rules, func(i, j int) bool {
+		pi, pj := rules[i].Path, rules[j].Path
+		wi, wj := strings.Contains(pi, "*"), strings.Contains(pj, "*")
+		if wi != wj {
+			return wi
+		}
+		li, lj := len(pi)-strings.Count(pi, "*"), len(pj)-strings.Count(pj, "*")
+		if li != lj {
+			return li < lj
+		}
+		return pi < pj
+	})
+}
+
+// Sets the headers of every rule matching a url path
+func (rules HeaderRules) Apply(header http.Header, urlpath string) {
+	for _, rule := range rules {
+		if !matchPath(rule.Path, urlpath) {
+			continue
+		}
+		for name, values := range rule.Headers {
+			header.Del(name)
+			for _, value := range values {
+				if value != "" {
+					header.Add(name, value)
+				}
+			}
+		}
+	}
+}
+
+// Whether a url path matches a pattern in which '*' matches anything
+func matchPath(pattern, urlpath string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == urlpath
+	}
+	if !strings.HasPrefix(urlpath, parts[0]) {
+		return false
+	}
+	urlpath = urlpath[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(urlpath, part)
+		if i < 0 {
+			return false
+		}
+		urlpath = urlpath[i+len(part):]
+	}
+	return strings.HasSuffix(urlpath, parts[len(parts)-1])
+}
+
+// Formats header rules as a '_headers' manifest: each path pattern on its
+// own line, followed by its headers indented
+func (rules HeaderRules) String() string {
+	var out bytes.Buffer
+	for _, rule := range rules {
+		out.WriteString(rule.Path + "\n")
+		names := make([]string, 0, len(rule.Headers))
+		for name := range rule.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range rule.Headers[name] {
+				fmt.Fprintf(&out, "  %s: %s\n", name, value)
+			}
+		}
+	}
+	return out.String()
+}
+
+// Parses a '_headers' manifest. Blank lines and lines starting with '#'
+// are ignored.
+func ParseHeaders(raw string) (HeaderRules, error) {
+	rules := make(HeaderRules, 0)
+	for i, line := range strings.Split(strings.Replace(raw, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line == trimmed {
+			rules = append(rules, HeaderRule{Path: trimmed, Headers: make(http.Header)})
+			continue
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon <= 0 || len(rules) == 0 {
+			return nil, &CustomError{fmt.Sprintf("line %d: expected an indented 'Name: value' after a path", i+1)}
+		}
+		rules[len(rules)-1].Headers.Add(strings.TrimSpace(trimmed[:colon]), strings.TrimSpace(trimmed[colon+1:]))
+	}
+	return rules, nil
+}
+
+// Writes the header rules of the site to the '_headers' manifest of the
+// build directory, for static hosts and the server
+func (b *Builder) BuildHeaders() error {
+	rules, err := b.Manager.HeaderRules()
+	if err != nil {
+		return err
+	}
+	return writeIfChanged(filepath.Join(b.Manager.Fspath, "build", "_headers"), []byte(rules.String()))
+}
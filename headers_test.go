@@ -0,0 +1,112 @@
+package main
+
+import "net/http"
+import "reflect"
+import "testing"
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		urlpath string
+		want    bool
+	}{
+		{"/about.html", "/about.html", true},
+		{"/about.html", "/about", false},
+		{"/*", "/", true},
+		{"/*", "/a/b/c.css", true},
+		{"/static/*", "/static/css/app.css", true},
+		{"/static/*", "/staticfile", false},
+		{"/*.css", "/static/app.css", true},
+		{"/*.css", "/static/app.js", false},
+		{"/blog/*/index.html", "/blog/2024/post/index.html", true},
+		{"/blog/*/index.html", "/blog/index.html", false},
+		{"/café/*", "/café/menü.html", true},
+	}
+	for _, test := range tests {
+		if got := matchPath(test.pattern, test.urlpath); got != test.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", test.pattern, test.urlpath, got, test.want)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want HeaderRules
+		err  bool
+	}{
+		{"empty", "", HeaderRules{}, false},
+		{"rules", "/*\n  X-Frame-Options: DENY\n# comment\n\n/static/*\n  Cache-Control: max-age=60\n  Link: <a>; rel=preload\n  Link: <b>; rel=preload\n",
+			HeaderRules{
+				{"/*", http.Header{"X-Frame-Options": {"DENY"}}},
+				{"/static/*", http.Header{"Cache-Control": {"max-age=60"}, "Link": {"<a>; rel=preload", "<b>; rel=preload"}}},
+			}, false},
+		{"windows line endings", "/a\r\n\tX-A: b: c\r\n", HeaderRules{{"/a", http.Header{"X-A": {"b: c"}}}}, false},
+		{"empty value", "/a\n  X-Frame-Options:\n", HeaderRules{{"/a", http.Header{"X-Frame-Options": {""}}}}, false},
+		{"header before a path", "  X-A: b\n", nil, true},
+		{"header without a colon", "/a\n  X-A\n", nil, true},
+	}
+	for _, test := range tests {
+		got, err := ParseHeaders(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("%s: ParseHeaders(%q) error = %v, want error %v", test.name, test.in, err, test.err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ParseHeaders(%q) = %v, want %v", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestHeaderRules(t *testing.T) {
+	m := &Manager{Site: DefaultSiteConfig()}
+	m.Site.ServerHeaders = map[string]string{"x-frame-options": "", "X-Server": "server", "X-Both": "server"}
+	m.Site.Headers = map[string]map[string]interface{}{
+		"/blog/post.html": {"X-Both": "exact"},
+		"/*":              {"X-Both": "all"},
+		"/blog/*":         {"X-Both": "blog", "Link": []interface{}{"<a>", "<b>"}},
+		"/*.html":         {"X-Html": "yes"},
+	}
+	rules, err := m.HeaderRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make([]string, len(rules))
+	for i, rule := range rules {
+		order[i] = rule.Path
+	}
+	if want := []string{"/*", "/*", "/*.html", "/blog/*", "/blog/post.html"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("rule order = %q, want %q", order, want)
+	}
+
+	tests := []struct {
+		urlpath string
+		want    http.Header
+	}{
+		{"/", http.Header{"X-Server": {"server"}, "X-Both": {"all"}}},
+		{"/blog/", http.Header{"X-Server": {"server"}, "X-Both": {"blog"}, "Link": {"<a>", "<b>"}}},
+		{"/blog/post.html", http.Header{"X-Server": {"server"}, "X-Both": {"exact"}, "Link": {"<a>", "<b>"}, "X-Html": {"yes"}}},
+	}
+	for _, test := range tests {
+		header := http.Header{"X-Frame-Options": {"SAMEORIGIN"}}
+		rules.Apply(header, test.urlpath)
+		if !reflect.DeepEqual(header, test.want) {
+			t.Errorf("headers of %s = %v, want %v", test.urlpath, header, test.want)
+		}
+	}
+
+	parsed, err := ParseHeaders(rules.String())
+	if err != nil || !reflect.DeepEqual(parsed, rules) {
+		t.Errorf("ParseHeaders(String()) = %v, %v, want %v", parsed, err, rules)
+	}
+
+	m.Site.Headers = map[string]map[string]interface{}{"static/*": {"X-A": "b"}}
+	if _, err := m.HeaderRules(); err == nil {
+		t.Errorf("relative pattern accepted")
+	}
+	m.Site.Headers = map[string]map[string]interface{}{"/*": {"X-A": 1.0}}
+	if _, err := m.HeaderRules(); err == nil {
+		t.Errorf("numeric header value accepted")
+	}
+}
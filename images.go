@@ -0,0 +1,404 @@
+package main
+
+import "bytes"
+import "crypto/sha1"
+import "encoding/hex"
+import "fmt"
+import "image"
+import "image/jpeg"
+import "image/png"
+import "io/ioutil"
+import "net/url"
+import "os"
+import "path"
+import "path/filepath"
+import "regexp"
+import "sort"
+import "strings"
+
+import "github.com/nfnt/resize"
+
+var imgTagRegexp = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+var imgSrcRegexp = regexp.MustCompile(`(?i)\ssrc\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+var imgSrcsetRegexp = regexp.MustCompile(`(?i)\ssrcset\s*=`)
+
+// A resized copy of a source image
+type ImageVariant struct {
+	Width int
+	Url   string
+}
+
+// Resizes and re-encodes the JPEG and PNG images referenced from rendered
+// pages. Derived images are cached in the workspace '.goblinimages'
+// directory so that unchanged images are not re-encoded on every build.
+type ImageProcessor struct {
+	Widths  []int
+	Quality int
+	Sizes   string
+
+	manager *Manager
+	pending map[string]string
+}
+
+func NewImageProcessor(m *Manager) *ImageProcessor {
+	ip := &ImageProcessor{manager: m, pending: make(map[string]string)}
+
+	ip.Widths = append(ip.Widths, m.Site.ImageWidths...)
+	sort.Ints(ip.Widths)
+	ip.Quality = m.Site.ImageQuality
+	ip.Sizes = m.Site.ImageSizes
+	if ip.Sizes == "" {
+		ip.Sizes = "100vw"
+	}
+	return ip
+}
+
+// Whether any image widths have been configured
+func (ip *ImageProcessor) Enabled() bool {
+	return len(ip.Widths) > 0
+}
+
+// Finds the workspace file for a root-relative image url among the static
+// files of the site.
+func (ip *ImageProcessor) locate(imgurl string) (string, bool) {
+	switch strings.ToLower(path.Ext(imgurl)) {
+	case ".jpg", ".jpeg", ".png":
+	default:
+		return "", false
+	}
+
+	return ip.manager.FindStatic(imgurl)
+}
+
+// Returns the url of the variant of imgurl with the given width
+func variantUrl(imgurl string, width int) string {
+	ext := path.Ext(imgurl)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(imgurl, ext), width, ext)
+}
+
+// Returns the variants generated for an image, narrowest first. The last
+// variant is the original itself unless it is wider than the largest
+// configured width, in which case it is a downscaled copy with a url of its
+// own: the original is left as the static sync copied it.
+func (ip *ImageProcessor) variants(source, imgurl string) ([]ImageVariant, error) {
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]ImageVariant, 0)
+	for _, width := range ip.Widths {
+		if width < cfg.Width {
+			variants = append(variants, ImageVariant{width, variantUrl(imgurl, width)})
+		}
+	}
+	if cfg.Width <= ip.Widths[len(ip.Widths)-1] {
+		variants = append(variants, ImageVariant{cfg.Width, imgurl})
+	}
+	return variants, nil
+}
+
+// Adds srcset and sizes attributes to every local image in html, pointing
+// the src of images wider than the largest configured width at their
+// downscaled copy. The page url is used to resolve relative image sources.
+// Each rewritten image is queued to be generated by Process.
+func (ip *ImageProcessor) RewriteImages(html, pageurl string) string {
+	if !ip.Enabled() {
+		return html
+	}
+	base, err := url.Parse(pageurl)
+	if err != nil {
+		return html
+	}
+	siteurl := strings.TrimSuffix(ip.manager.Site.Url, "/")
+
+	return imgTagRegexp.ReplaceAllStringFunc(html, func(tag string) string {
+		if imgSrcsetRegexp.MatchString(tag) {
+			return tag
+		}
+		loc := imgSrcRegexp.FindStringSubmatchIndex(tag)
+		if loc == nil {
+			return tag
+		}
+		var src string
+		if loc[2] >= 0 {
+			src = tag[loc[2]:loc[3]]
+		} else {
+			src = tag[loc[4]:loc[5]]
+		}
+		prefix := ""
+		if siteurl != "" && strings.HasPrefix(src, siteurl+"/") {
+			src = strings.TrimPrefix(src, siteurl)
+			prefix = siteurl
+		}
+
+		ref, err := url.Parse(src)
+		if err != nil || ref.Scheme != "" || ref.Host != "" {
+			return tag
+		}
+		imgurl := base.ResolveReference(ref).Path
+
+		source, ok := ip.locate(imgurl)
+		if !ok {
+			return tag
+		}
+		variants, err := ip.variants(source, imgurl)
+		if err != nil {
+			OUT.Errorf("could not read image '%s': %s", source, err)
+			return tag
+		}
+		ip.pending[imgurl] = source
+
+		if largest := variants[len(variants)-1]; largest.Url != imgurl {
+			tag = tag[:loc[0]] + tag[loc[0]:loc[0]+1] + `src="` + prefix + largest.Url + `"` + tag[loc[1]:]
+		}
+
+		srcset := make([]string, len(variants))
+		for i, v := range variants {
+			srcset[i] = fmt.Sprintf("%s %dw", v.Url, v.Width)
+		}
+		attrs := fmt.Sprintf(` srcset="%s" sizes="%s"`, strings.Join(srcset, ", "), ip.Sizes)
+
+		if strings.HasSuffix(tag, "/>") {
+			return strings.TrimSuffix(strings.TrimSuffix(tag, "/>"), " ") + attrs + " />"
+		}
+		return strings.TrimSuffix(tag, ">") + attrs + ">"
+	})
+}
+
+// The workspace file recording, for each image whose variants are in the
+// build directory, the hash of its source and the urls of its variants
+const imageRecordsName = ".goblinsrcsets"
+
+func (ip *ImageProcessor) records() (*Config, error) {
+	records := NewConfig(filepath.Join(ip.manager.Fspath, imageRecordsName))
+	if Exists(records.filename) {
+		err := records.parse()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Returns the source hash and the variant urls of an image record
+func imageRecord(value interface{}) (string, []string) {
+	record, _ := value.(map[string]interface{})
+	hash, _ := record["hash"].(string)
+	variants := make([]string, 0)
+	list, _ := record["variants"].([]interface{})
+	for _, v := range list {
+		if v, ok := v.(string); ok {
+			variants = append(variants, v)
+		}
+	}
+	return hash, variants
+}
+
+// Whether an image whose variants a previous build generated has changed or
+// is gone, in which case the pages referring to it must be rendered again
+func (ip *ImageProcessor) Changed() (bool, error) {
+	records, err := ip.records()
+	if err != nil {
+		return false, err
+	}
+	for imgurl, record := range records.data {
+		source, ok := ip.locate(imgurl)
+		if !ok {
+			return true, nil
+		}
+		sum, err := hashFile(source)
+		if err != nil {
+			return false, err
+		}
+		if hash, _ := imageRecord(record); hash != sum {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Generates the variants of the images queued by RewriteImages, and of the
+// images of previous builds which are still static files, into the build
+// directory. Cached images are reused where the source has not changed, and
+// variants of images no longer processed are removed. Originals are left as
+// the static sync copied them.
+func (ip *ImageProcessor) Process() error {
+	cachedir := filepath.Join(ip.manager.Fspath, ".goblinimages")
+	err := os.MkdirAll(cachedir, 0755)
+	if err != nil {
+		return err
+	}
+	records, err := ip.records()
+	if err != nil {
+		return err
+	}
+
+	// pages which were not rendered again still refer to the images of
+	// previous builds
+	images := make(map[string]string)
+	if ip.Enabled() {
+		for imgurl := range records.data {
+			if source, ok := ip.locate(imgurl); ok {
+				images[imgurl] = source
+			}
+		}
+	}
+	for imgurl, source := range ip.pending {
+		images[imgurl] = source
+	}
+
+	outputs := NewConfig(records.filename)
+	produced := make(map[string]bool)
+	for imgurl, source := range images {
+		key, urls, err := ip.generate(imgurl, source, cachedir)
+		if err != nil {
+			return err
+		}
+		list := make([]interface{}, len(urls))
+		for i, u := range urls {
+			list[i] = u
+			produced[u] = true
+		}
+		outputs.Set(imgurl, map[string]interface{}{"hash": key, "variants": list})
+	}
+
+	for _, record := range records.data {
+		_, urls := imageRecord(record)
+		for _, u := range urls {
+			if produced[u] {
+				continue
+			}
+			// variants which are now static files themselves are left alone
+			if _, ok := ip.manager.FindStatic(u); ok {
+				continue
+			}
+			err := os.Remove(filepath.Join(ip.manager.Fspath, "build", filepath.FromSlash(strings.TrimPrefix(u, "/"))))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	ip.pending = make(map[string]string)
+	err = SaveConfig(outputs)
+	if err != nil {
+		return err
+	}
+	return ip.prune(cachedir)
+}
+
+// Writes the variants of an image into the build directory, returning the
+// hash of its source and the urls of the variants written
+func (ip *ImageProcessor) generate(imgurl, source, cachedir string) (string, []string, error) {
+	raw, err := ioutil.ReadFile(source)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha1.Sum(raw)
+	key := hex.EncodeToString(sum[:])
+
+	variants, err := ip.variants(source, imgurl)
+	if err != nil {
+		return "", nil, err
+	}
+
+	urls := make([]string, 0, len(variants))
+	var img image.Image
+	for _, v := range variants {
+		if v.Url == imgurl {
+			continue
+		}
+		urls = append(urls, v.Url)
+		ext := strings.ToLower(path.Ext(imgurl))
+		cached := filepath.Join(cachedir, fmt.Sprintf("%s-%d-q%d%s", key, v.Width, ip.Quality, ext))
+
+		if !Exists(cached) {
+			if img == nil {
+				img, _, err = image.Decode(bytes.NewReader(raw))
+				if err != nil {
+					return "", nil, &CustomError{fmt.Sprintf("cannot decode '%s': %s", source, err)}
+				}
+			}
+			err = ip.encode(img, v.Width, ext, cached)
+			if err != nil {
+				os.Remove(cached)
+				return "", nil, err
+			}
+		}
+
+		// left untouched when unchanged, so that it is not compressed again
+		dest := filepath.Join(ip.manager.Fspath, "build", filepath.FromSlash(strings.TrimPrefix(v.Url, "/")))
+		if current, err := hashFile(dest); err == nil {
+			if wanted, err := hashFile(cached); err == nil && current == wanted {
+				continue
+			}
+		}
+		err = os.MkdirAll(filepath.Dir(dest), 0755)
+		if err != nil {
+			return "", nil, err
+		}
+		err = CopyFile(cached, dest)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return key, urls, nil
+}
+
+// Removes the cached images of sources which are no longer static files of
+// the site, going by the hashes recorded by the last static sync
+func (ip *ImageProcessor) prune(cachedir string) error {
+	records, err := LoadConfig(filepath.Join(ip.manager.Fspath, ".goblinstatic"))
+	if err != nil {
+		// without records every cached image is kept
+		return nil
+	}
+	live := make(map[string]bool)
+	for _, sum := range records.data {
+		if sum, ok := sum.(string); ok {
+			live[sum] = true
+		}
+	}
+
+	entries, err := ioutil.ReadDir(cachedir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		key := strings.SplitN(entry.Name(), "-", 2)[0]
+		if !live[key] {
+			err = os.Remove(filepath.Join(cachedir, entry.Name()))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Resizes img to width and encodes it to the file dest. JPEGs are encoded at
+// the configured quality, PNGs are lossless and use the best compression.
+func (ip *ImageProcessor) encode(img image.Image, width int, ext, dest string) error {
+	if img.Bounds().Dx() > width {
+		img = resize.Resize(uint(width), 0, img, resize.Lanczos3)
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if ext == ".png" {
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		return encoder.Encode(file, img)
+	}
+	return jpeg.Encode(file, img, &jpeg.Options{Quality: ip.Quality})
+}
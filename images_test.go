@@ -0,0 +1,132 @@
+package main
+
+import "image"
+import "image/jpeg"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+
+// Writes a blank jpeg of the given width to name
+func writeTestJPEG(t *testing.T, name string, width int) {
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	err = jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, width, width/2)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the width of the image at name
+func imageWidth(t *testing.T, name string) int {
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	cfg, _, err := image.DecodeConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Width
+}
+
+func TestImagesAcrossBuilds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.MkdirAll(filepath.Join(dir, "src", "static"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hero := filepath.Join(dir, "src", "static", "hero.jpg")
+	writeTestJPEG(t, hero, 400)
+
+	site := DefaultSiteConfig()
+	site.ImageWidths = []int{100}
+	m := &Manager{Fspath: dir, Site: site, Theme: &Theme{Name: "none", Path: filepath.Join(dir, "themes", "none")}}
+
+	// renders the pages given, as a build whose pages are unchanged
+	// renders none, and returns whether the images changed
+	build := func(pages ...string) ([]string, bool) {
+		_, _, err := m.SyncStatic()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip := NewImageProcessor(m)
+		changed, err := ip.Changed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([]string, len(pages))
+		for i, page := range pages {
+			out[i] = ip.RewriteImages(page, "/")
+		}
+		err = ip.Process()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out, changed
+	}
+	original := filepath.Join(dir, "build", "hero.jpg")
+	variant := filepath.Join(dir, "build", "hero-100w.jpg")
+
+	out, _ := build(`<img src="hero.jpg">`)
+	if want := `<img src="/hero-100w.jpg" srcset="/hero-100w.jpg 100w" sizes="100vw">`; out[0] != want {
+		t.Errorf("first build rendered %q, want %q", out[0], want)
+	}
+	if w := imageWidth(t, original); w != 400 {
+		t.Errorf("first build: original is %dpx wide, want 400", w)
+	}
+	if w := imageWidth(t, variant); w != 100 {
+		t.Errorf("first build: variant is %dpx wide, want 100", w)
+	}
+
+	_, changed := build()
+	if changed {
+		t.Errorf("second build: images changed without any source change")
+	}
+	if w := imageWidth(t, original); w != 400 {
+		t.Errorf("second build: original is %dpx wide, want 400", w)
+	}
+	if w := imageWidth(t, variant); w != 100 {
+		t.Errorf("second build: variant is %dpx wide, want 100", w)
+	}
+
+	// a variant lost from the build is generated again
+	os.Remove(variant)
+	build()
+	if !Exists(variant) {
+		t.Errorf("third build: variant was not generated again")
+	}
+
+	// a source which no longer needs resizing rebuilds the pages using it
+	writeTestJPEG(t, hero, 80)
+	out, changed = build(`<img src="/hero.jpg" />`)
+	if !changed {
+		t.Errorf("fourth build: changed source not detected")
+	}
+	if want := `<img src="/hero.jpg" srcset="/hero.jpg 80w" sizes="100vw" />`; out[0] != want {
+		t.Errorf("fourth build rendered %q, want %q", out[0], want)
+	}
+	if Exists(variant) {
+		t.Errorf("fourth build: stale variant was not removed")
+	}
+	if w := imageWidth(t, original); w != 80 {
+		t.Errorf("fourth build: original is %dpx wide, want 80", w)
+	}
+
+	cached, err := ioutil.ReadDir(filepath.Join(dir, ".goblinimages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached) != 0 {
+		t.Errorf("cache holds %d images of a source which is gone, want 0", len(cached))
+	}
+}
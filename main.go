@@ -1,6 +1,5 @@
 package main
 
-import "fmt"
 import "io/ioutil"
 import "net/http"
 import "os"
@@ -10,7 +9,6 @@ import "time"
 
 import "github.com/aisola/reporter"
 import "github.com/codegangsta/cli"
-import "github.com/flosch/pongo"
 import "github.com/inconshreveable/mousetrap"
 
 const VERSION = "0.3"
@@ -69,27 +67,61 @@ func main() {
                 
                 IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "creating '%s'", filepath.Join(site_directory, "themes", "default"))
                 os.MkdirAll(filepath.Join(site_directory, "themes", "default"), os.FileMode(0700))
+                err := WriteDefaultTheme(filepath.Join(site_directory, "themes", "default"))
+                if err != nil { OUT.Errorf("could not create default theme: %s", err) }
                 
                 // setup config
                 IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "creating '%s'", filepath.Join(site_directory, "config.json"))
                 config := NewConfig(filepath.Join(site_directory, "config.json"))
+                config.Set("title", "My Goblin Site")
                 config.Set("url", "")
                 config.Set("author", "")
+                config.Set("copyright", "Copyright %d")
                 config.Set("theme", "default")
                 SaveConfig(config)
                 
                 IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "creating '%s'", filepath.Join(site_directory, "src", "pages", "index.md"))
-                err := CreateSimpleFile(filepath.Join(site_directory, "src", "pages", "index.md"), IndexMD, 0644)
+                err = CreateSimpleFile(filepath.Join(site_directory, "src", "pages", "index.md"), IndexMD, 0644)
                 if err != nil { OUT.Errorf("could not create index.md: %s", err) }
             },
         },
         
+        {
+            Name: "new",
+            Usage: "create a page or post from its archetype",
+            Description: "The new command creates content in the workspace given by the site option\n   (default: the current directory), from archetypes/page.md or\n   archetypes/post.md, falling back to archetypes/default.md and then the\n   built-in archetypes. The title, slug, date, author and layout\n   placeholders, written as {{ title }}, are filled in.\n\n   goblin new page <path> \"Title\"  create src/pages/<path>.md\n   goblin new post \"Title\"         create src/pages/<date>-<slug>.md",
+            Flags: []cli.Flag{
+                cli.StringFlag{"site, s", ".", "the site workspace directory"},
+            },
+            Action: func (ctx *cli.Context) {
+                var argc = len(ctx.Args())
+                var path string
+                
+                manager, err := LoadManager(ctx.String("site"), "")
+                OUT.FatalOnError(err, "%s", err)
+                
+                switch ctx.Args().First() {
+                case "page":
+                    if argc != 3 { OUT.Fatal("new page takes a path and a title") }
+                    path, err = NewPage(manager, ctx.Args().Get(1), ctx.Args().Get(2))
+                case "post":
+                    if argc != 2 { OUT.Fatal("new post takes a title") }
+                    path, err = NewPost(manager, ctx.Args().Get(1))
+                default:
+                    OUT.Fatal("new takes one of page or post")
+                }
+                OUT.FatalOnError(err, "cannot create %s: %s", ctx.Args().First(), err)
+                OUT.Infof("created '%s'", path)
+            },
+        },
+        
         {   // TODO: impliment posts
             Name: "build",
             Usage: "build the static site",
-            Description: "The build command compiles each of the pages and posts into html and \n   matches them with their layout. The build will only build files that \n   have not been modified since their last build. If the all/a option is \n   set all of the pages/posts will be compiled regardless of whether \n   they have have been modified or not.",
+            Description: "The build command compiles each of the pages and posts into html and \n   matches them with their layout. The build will only build files that \n   have not been modified since their last build. If the all/a option is \n   set all of the pages/posts will be compiled regardless of whether \n   they have have been modified or not. The env option names the \n   config overlay (e.g. 'config.production.json') merged over the site \n   config; GOBLIN_* environment variables override single keys.",
             Flags: []cli.Flag{
                 cli.BoolFlag{"all, a", "build all files regardless of the last modified date"},
+                cli.StringFlag{"env, e", "production", "the environment whose config overlay is merged (default: production)"},
                 // cli.BoolFlag{"file, f", "build a specific file"},
                 // TODO: cli.BoolFlag{"pages, p", "pages build only"},
                 // TODO: cli.BoolFlag{"posts", "build posts only"},
@@ -97,7 +129,6 @@ func main() {
             Action: func (ctx *cli.Context) {
                 var site_directory string
                 var argc = len(ctx.Args())
-                var html_name string
                 
                 // set where the site workspace will be
                 if argc == 0 {
@@ -109,63 +140,26 @@ func main() {
                 }
                 
                 IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "loading site configuration\n")
-                manager := LoadManager(filepath.Join(site_directory, "config.json"))
-                manager.LoadPages()
-                pages := manager.CheckPages(ctx.IsSet("all"))
+                manager, err := LoadManager(site_directory, ctx.String("env"))
+                OUT.FatalOnError(err, "%s", err)
                 
-                for i := 0; i < len(pages); i++ {
-                    IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "now building '%s'", pages[i].Name())
-                    
-                    page := manager.LoadPage(pages[i])
-                    
-                    if page.Url == "" {
-                        html_name = filepath.Join(manager.Fspath, "build", strings.Replace(page.Fi.Name(), ".md", ".html", -1))
-                    } else {
-                        err := os.MkdirAll(filepath.Join(manager.Fspath, "build", page.Url), 0755)
-                        OUT.FatalOnError(err, "cannot create necessary directory: %s", err)
-                        html_name = filepath.Join(manager.Fspath, "build", page.Url, "index.html")
-                    }
-                    
-                    _ = os.Remove(html_name)
-                    
-                    layoutpath := filepath.Join(manager.Fspath, "themes", manager.Config.GetString("theme"), fmt.Sprintf("%s.html",page.Layout))
-                    pongocontext := &pongo.Context{
-                        "site_title": manager.Config.GetString("title"),
-                        "site_url": manager.Config.GetString("url"),
-                        "site_author": manager.Config.GetString("author"),
-                        "site_copyright": fmt.Sprintf(manager.Config.GetString("copyright"), time.Now().Year()),
-                        
-                        "page_title": page.Title,
-                        "page_author": page.Author,
-                        
-                        "content": string(RenderMarkdown(page.Content)),
-                        
-                        "goblin_powered": `This website powered by the <a href="https://github.com/aisola/goblin.git" target="_blank">Goblin</a> Static Site Directory.`,
-                    }
-                    
-                    theme_out := RenderTheme(layoutpath, pongocontext)
-                    
-                    err := CreateSimpleFile(html_name, theme_out, 0644)
-                    if err != nil { OUT.Errorf("could not build %s: %s", page.Fi.Name(), err) }
-                }
-                manager.SaveRecords()
-                
-                IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "copying theme static directory\n")
-                
-                staticdir_theme := filepath.Join(manager.Fspath, "themes", manager.Config.GetString("theme"), "static")
-                staticdir_build := filepath.Join(manager.Fspath, "build", "static")
-                
-                err := CopyDir(staticdir_theme, staticdir_build)
-                OUT.FatalOnError(err, "cannot copy static directory: %s", err)
+                builder := NewBuilder(manager, ctx.GlobalBool("verbose"))
+                err = builder.Build(ctx.IsSet("all"))
+                OUT.FatalOnError(err, "%s", err)
             },
         },
         
         {
             Name: "serve",
             Usage: "run the static site in a server",
-            Description: "The serve command creates a server (rooted in the workspace 'build' \n   'build' directory). The bind option sets where the server should \n   serve. (default: localhost:8080)",
+            Description: "The serve command creates a server (rooted in the workspace 'build' \n   directory). The bind option sets where the server should serve. \n   (default: :8080) Missing paths are answered with the site's 404 page \n   and responses get the headers of the server_headers and headers \n   configs. The render option renders pages on request instead, \n   drafts included, leaving the 'build' directory untouched. The tls \n   option serves over HTTPS with a self-signed certificate cached in \n   the workspace '.goblincert' directory.",
             Flags: []cli.Flag{
                 cli.StringFlag{"bind",":8080",`the server address to bind to (default: ":8080")`},
+                cli.StringFlag{"env, e","development","the environment whose config overlay is merged (default: development)"},
+                cli.BoolFlag{"watch, w","rebuild the site when its sources change and reload open pages"},
+                cli.BoolFlag{"render, r","render pages on request without building, drafts included"},
+                cli.BoolFlag{"tls","serve over HTTPS with a self-signed certificate"},
+                cli.StringFlag{"hosts","","comma separated hostnames the certificate is valid for besides localhost"},
             },
             Action: func (ctx *cli.Context) {
                 var site_directory string
@@ -181,9 +175,56 @@ func main() {
                 }
                 
                 IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "loading site configuration")
-                manager := LoadManager(filepath.Join(site_directory, "config.json"))
-                IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "serving '%s'\n", filepath.Join(manager.Fspath,"build"))
-                err := http.ListenAndServe(":8080", http.FileServer(http.Dir(filepath.Join(manager.Fspath,"build"))))
+                manager, err := LoadManager(site_directory, ctx.String("env"))
+                OUT.FatalOnError(err, "%s", err)
+                
+                var certfile, keyfile string
+                if ctx.Bool("tls") {
+                    hosts := make([]string, 0)
+                    for _, host := range strings.Split(ctx.String("hosts"), ",") {
+                        if host = strings.TrimSpace(host); host != "" {
+                            hosts = append(hosts, host)
+                        }
+                    }
+                    var err error
+                    certfile, keyfile, err = LocalCertificate(manager.Fspath, hosts)
+                    OUT.FatalOnError(err, "cannot create a certificate: %s", err)
+                    IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "using the certificate '%s'", certfile)
+                }
+                
+                if ctx.Bool("render") {
+                    if ctx.Bool("watch") {
+                        OUT.Fatal("--render cannot be combined with --watch")
+                    }
+                    preview, err := NewPreviewHandler(manager, manager.ServerHeaders())
+                    OUT.FatalOnError(err, "cannot start the preview server: %s", err)
+                    server := &http.Server{Addr: ctx.String("bind"), Handler: LogRequests(preview)}
+                    OUT.Infof("previewing '%s' on %s", manager.Fspath, server.Addr)
+                    err = Serve(server, certfile, keyfile)
+                    OUT.FatalOnError(err, "server had an error: %s", err)
+                    return
+                }
+                
+                handler := NewSiteHandler(filepath.Join(manager.Fspath,"build"), manager.ServerHeaders())
+                
+                if ctx.Bool("watch") {
+                    builder := NewBuilder(manager, ctx.GlobalBool("verbose"))
+                    err := builder.Build(false)
+                    OUT.FatalOnError(err, "%s", err)
+                    
+                    handler.LiveReload = NewLiveReload()
+                    err = builder.Watch(handler.LiveReload)
+                    OUT.FatalOnError(err, "cannot watch the workspace: %s", err)
+                    OUT.Infof("watching '%s' for changes", manager.Fspath)
+                }
+                
+                server := &http.Server{Addr: ctx.String("bind"), Handler: LogRequests(handler)}
+                if handler.LiveReload != nil {
+                    server.RegisterOnShutdown(handler.LiveReload.Close)
+                }
+                
+                OUT.Infof("serving '%s' on %s", filepath.Join(manager.Fspath,"build"), server.Addr)
+                err = Serve(server, certfile, keyfile)
                 OUT.FatalOnError(err, "server had an error: %s", err)
             },
         },
@@ -224,6 +265,87 @@ func main() {
                 }
             },
         },
+        
+        {
+            Name: "theme",
+            Usage: "list, create, install and remove themes",
+            Description: "The theme command manages the themes of the workspace given by the \n   site option (default: the current directory).\n\n   goblin theme list                   list the installed themes\n   goblin theme new <name>             create a theme skeleton\n   goblin theme install <dir|archive>  install a theme from a directory,\n                                       .zip or .tar.gz archive\n   goblin theme remove <name>          remove an installed theme",
+            Flags: []cli.Flag{
+                cli.StringFlag{"site, s", ".", "the site workspace directory"},
+                cli.StringFlag{"parent", "", "the parent of a new theme"},
+                cli.StringFlag{"name", "", "install the theme under this name"},
+                cli.BoolFlag{"force, f", "replace or remove themes that are in use"},
+            },
+            Action: func (ctx *cli.Context) {
+                var argc = len(ctx.Args())
+                var err error
+                
+                if argc == 0 {
+                    OUT.Fatal("theme takes one of list, new, install or remove")
+                }
+                
+                IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "loading site configuration")
+                manager, err := LoadManager(ctx.String("site"), "")
+                OUT.FatalOnError(err, "%s", err)
+                
+                switch ctx.Args().First() {
+                case "list":
+                    err = ListThemes(manager)
+                    OUT.FatalOnError(err, "cannot list themes: %s", err)
+                case "new":
+                    if argc != 2 { OUT.Fatal("theme new takes a theme name") }
+                    IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "creating theme '%s'", ctx.Args().Get(1))
+                    err = NewTheme(manager, ctx.Args().Get(1), ctx.String("parent"))
+                    OUT.FatalOnError(err, "cannot create theme: %s", err)
+                case "install":
+                    if argc != 2 { OUT.Fatal("theme install takes a theme directory or archive") }
+                    IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "installing theme from '%s'", ctx.Args().Get(1))
+                    err = InstallTheme(manager, ctx.Args().Get(1), ctx.String("name"), ctx.Bool("force"))
+                    OUT.FatalOnError(err, "cannot install theme: %s", err)
+                case "remove":
+                    if argc != 2 { OUT.Fatal("theme remove takes a theme name") }
+                    IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "removing theme '%s'", ctx.Args().Get(1))
+                    err = RemoveTheme(manager, ctx.Args().Get(1), ctx.Bool("force"))
+                    OUT.FatalOnError(err, "cannot remove theme: %s", err)
+                default:
+                    OUT.Fatal("theme takes one of list, new, install or remove")
+                }
+            },
+        },
+        
+        {
+            Name: "config",
+            Usage: "get, set and list config keys",
+            Description: "The config command reads and edits the config of the workspace given by\n   the site option (default: the current directory). Keys are dotted paths,\n   such as social.twitter.\n\n   goblin config get <key>          print the value of a key\n   goblin config set <key> <value>  set a key in the config file, keeping\n                                    its comments and layout; values are\n                                    json unless the key holds a string\n   goblin config list               print every key and its value",
+            Flags: []cli.Flag{
+                cli.StringFlag{"site, s", ".", "the site workspace directory"},
+                cli.StringFlag{"env, e", "", "read the config as seen in this environment"},
+            },
+            Action: func (ctx *cli.Context) {
+                var argc = len(ctx.Args())
+                var err error
+                
+                switch ctx.Args().First() {
+                case "get":
+                    if argc != 2 { OUT.Fatal("config get takes a key") }
+                    err = ConfigGet(ctx.String("site"), ctx.String("env"), ctx.Args().Get(1))
+                    OUT.FatalOnError(err, "%s", err)
+                case "set":
+                    if argc != 3 { OUT.Fatal("config set takes a key and a value") }
+                    IfTrueExec(ctx.GlobalBool("verbose"), OUT.Infof, "setting '%s'", ctx.Args().Get(1))
+                    warnings, err := ConfigSet(ctx.String("site"), ctx.Args().Get(1), ctx.Args().Get(2))
+                    OUT.FatalOnError(err, "cannot set config key: %s", err)
+                    for _, w := range warnings {
+                        OUT.Infof("warning: %s", w)
+                    }
+                case "list":
+                    err = ConfigList(ctx.String("site"), ctx.String("env"))
+                    OUT.FatalOnError(err, "%s", err)
+                default:
+                    OUT.Fatal("config takes one of get, set or list")
+                }
+            },
+        },
     }
     
     app.Run(os.Args)
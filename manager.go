@@ -1,10 +1,14 @@
 package main
 
+import "crypto/sha1"
+import "encoding/hex"
+import "encoding/json"
 import "io/ioutil"
 import "os"
 import "path/filepath"
 import "strconv"
 import "strings"
+import "time"
 
 type Page struct {
 	Fi      os.FileInfo
@@ -16,44 +20,145 @@ type Page struct {
 	Layout  string
 	Slug    string
 	Url     string
+	Date    time.Time
 	Mainnav bool
 	Order   int
+	Draft   bool
+	Aliases []string
+}
+
+// Returns the root-relative url the page is built to
+func (p *Page) Permalink() string {
+	if p.Url == "" {
+		return "/" + strings.Replace(p.Fi.Name(), ".md", ".html", -1)
+	}
+	return strings.TrimSuffix("/"+strings.Trim(p.Url, "/"), "/") + "/"
+}
+
+// Returns the page values exposed to templates
+func (p *Page) Params() map[string]interface{} {
+	return map[string]interface{}{
+		"title":   p.Title,
+		"author":  p.Author,
+		"layout":  p.Layout,
+		"slug":    p.Slug,
+		"url":     p.Permalink(),
+		"date":    p.Date,
+		"mainnav": p.Mainnav,
+		"order":   p.Order,
+		"draft":   p.Draft,
+		"aliases": p.Aliases,
+	}
 }
 
 type Manager struct {
 	Config      *Config
+	Site        *SiteConfig
+	Env         string
 	Fspath      string
 	Pages       []os.FileInfo
+	Theme       *Theme
+	Assets      *AssetPipeline
+
+	engine      TemplateEngine
 }
 
-func LoadManager(path string) *Manager {
-    config := LoadConfig(path)
-    fspath, _ := filepath.Abs(filepath.Dir(path))
-    man := &Manager{Config: config, Fspath: fspath}
-    return man
+// Loads the manager of a workspace directory, from its config for the given
+// environment. Warnings about the configuration are reported but do not fail
+// the load.
+func LoadManager(dir, env string) (*Manager, error) {
+    config, envwarnings, err := LoadEnvConfig(dir, env)
+    if err != nil {
+        return nil, err
+    }
+    site, warnings, err := NewSiteConfig(config)
+    if err != nil {
+        return nil, &CustomError{"invalid config file " + config.filename + ": " + err.Error()}
+    }
+    for _, warning := range append(envwarnings, warnings...) {
+        OUT.Infof("warning: %s", warning)
+    }
+    fspath, _ := filepath.Abs(dir)
+    man := &Manager{Config: config, Site: site, Env: env, Fspath: fspath}
+    return man, nil
 }
 
-func (m *Manager) LoadPages() {
+func (m *Manager) LoadPages() error {
+	m.Pages = make([]os.FileInfo, 0)
 	pagefiles, err := ioutil.ReadDir(filepath.Join(m.Fspath, "src", "pages"))
-	OUT.FatalOnError(err, "could not read directory '%s': %s", filepath.Join(m.Fspath, "src", "pages"), err)
+	if err != nil {
+		return &CustomError{"could not read directory '" + filepath.Join(m.Fspath, "src", "pages") + "': " + err.Error()}
+	}
 
 	for i := 0; i < len(pagefiles); i++ {
 		m.Pages = append(m.Pages, pagefiles[i])
 	}
+	return nil
+}
+
+func (m *Manager) LoadTheme() error {
+	theme, err := LoadTheme(m.Fspath, m.Site.Theme)
+	if err != nil {
+		return &CustomError{"could not load theme: " + err.Error()}
+	}
+	m.Theme = theme
+	m.engine = nil
+	return nil
+}
+
+// The key of the page records holding the hash of the config the pages were
+// built with, as the environment a build runs in changes its pages
+const configRecordKey = ".config"
+
+// The key of the page records holding the hash of the page list the pages
+// were built with, as every page may list the others
+const pagesRecordKey = ".pages"
+
+// Returns a hash of the merged config of the site
+func (m *Manager) configHash() string {
+    raw, _ := json.Marshal(m.Config.data)
+    sum := sha1.Sum(raw)
+    return hex.EncodeToString(sum[:])
+}
+
+// Returns a hash of the template values of a list of pages
+func pagesHash(list []map[string]interface{}) string {
+    raw, _ := json.Marshal(list)
+    sum := sha1.Sum(raw)
+    return hex.EncodeToString(sum[:])
 }
 
-func (m *Manager) SaveRecords() {
+// Records the pages built, along with the config and the site pages they
+// were built with
+func (m *Manager) SaveRecords(list []map[string]interface{}) {
     // setup config
     config := NewConfig(filepath.Join(m.Fspath, ".goblinpages"))
     for i := 0; i < len(m.Pages); i++ {
 		config.Set(m.Pages[i].Name(), m.Pages[i].ModTime().String())
 	}
+    config.Set(configRecordKey, m.configHash())
+    config.Set(pagesRecordKey, pagesHash(list))
     SaveConfig(config)
 }
 
-func (m *Manager) CheckPages(all bool) []os.FileInfo {
+// Returns the pages to build: those modified since the last build, or all of
+// them when all is set or when the config or the page list differ from
+// those of the last build
+func (m *Manager) CheckPages(all bool, list []map[string]interface{}) []os.FileInfo {
     if all == false && Exists(filepath.Join(m.Fspath, ".goblinpages")) {
-        gobpages := LoadConfig(filepath.Join(m.Fspath, ".goblinpages"))
+        gobpages, err := LoadConfig(filepath.Join(m.Fspath, ".goblinpages"))
+        if err != nil {
+            // without valid records every page is rebuilt
+            return m.Pages
+        }
+        if gobpages.GetString(configRecordKey) != m.configHash() {
+            // pages built with another config or environment are all stale
+            return m.Pages
+        }
+        if gobpages.GetString(pagesRecordKey) != pagesHash(list) {
+            // a page was added, removed or had its front matter changed
+            return m.Pages
+        }
         
         rpages := make([]os.FileInfo, 0)
         
@@ -68,7 +173,7 @@ func (m *Manager) CheckPages(all bool) []os.FileInfo {
 	return m.Pages
 }
 
-func (m *Manager) loadpagevalues(page *Page) {
+func (m *Manager) loadpagevalues(page *Page) error {
 	unixraw := strings.Replace(string(page.Raw), "\r\n", "\n", -1)
 	lines := strings.Split(unixraw, "\n")
 
@@ -99,12 +204,24 @@ func (m *Manager) loadpagevalues(page *Page) {
 					}
 				case "order":
 					val, err := strconv.ParseInt(value, 10, 32)
-					OUT.FatalOnError(err, "value of 'order' must be an integer in '%s'", page.Fi.Name())
+					if err != nil {
+						return &CustomError{"value of 'order' must be an integer in '" + page.Fi.Name() + "'"}
+					}
 					page.Order = int(val)
 				case "url":
 					page.Url = value
+				case "date":
+					date, err := ParseDate(value)
+					if err != nil {
+						return &CustomError{"value of 'date' must be a date in '" + page.Fi.Name() + "'"}
+					}
+					page.Date = date
 				case "slug":
 					page.Slug = value
+				case "draft":
+					page.Draft = value == "true"
+				case "aliases":
+					page.Aliases = parseList(value)
 				}
 
 			}
@@ -121,18 +238,43 @@ func (m *Manager) loadpagevalues(page *Page) {
 
 	}
 	page.Content = strings.Join(lines, "\n")
+	return nil
 }
 
-func (m *Manager) LoadPage(fi os.FileInfo) Page {
-	file, err := os.Open(filepath.Join(m.Fspath, "src", "pages", fi.Name()))
-	OUT.FatalOnError(err, "could load '%s': %s", fi.Name(), err)
-
+func (m *Manager) LoadPage(fi os.FileInfo) (Page, error) {
 	page := Page{}
 	page.Fi = fi
-	page.Raw, err = ioutil.ReadAll(file)
-	OUT.FatalOnError(err, "could load '%s': %s", fi.Name(), err)
-	m.loadpagevalues(&page)
+	raw, err := ioutil.ReadFile(filepath.Join(m.Fspath, "src", "pages", fi.Name()))
+	if err != nil {
+		return page, &CustomError{"could not load '" + fi.Name() + "': " + err.Error()}
+	}
+	page.Raw = raw
+	err = m.loadpagevalues(&page)
+	return page, err
+}
+
+// Returns the template values of every page in the site
+func (m *Manager) PageList() ([]map[string]interface{}, error) {
+	list := make([]map[string]interface{}, 0, len(m.Pages))
+	for i := 0; i < len(m.Pages); i++ {
+		page, err := m.LoadPage(m.Pages[i])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, page.Params())
+	}
+	return list, nil
+}
 
-	file.Close()
-	return page
+// Parses a front matter list, written either as '[a, b]' or 'a, b'
+func parseList(value string) []string {
+	list := make([]string, 0)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	for _, item := range strings.Split(value, ",") {
+		item = strings.Trim(strings.TrimSpace(item), `"'`)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }
@@ -0,0 +1,59 @@
+package main
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+
+func TestCheckPagesAfterPageListChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pagedir := filepath.Join(dir, "src", "pages")
+	err = os.MkdirAll(pagedir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, title string) {
+		err := ioutil.WriteFile(filepath.Join(pagedir, name), []byte("---\ntitle: "+title+"\n---\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := NewConfig(filepath.Join(dir, "goblin.json"))
+	m := &Manager{Config: config, Site: DefaultSiteConfig(), Fspath: dir}
+
+	// loads the pages, returning those to build, and records them as built
+	build := func() int {
+		err := m.LoadPages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := m.PageList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := m.CheckPages(false, list)
+		m.SaveRecords(list)
+		return len(pages)
+	}
+
+	write("a.md", "A")
+	if n := build(); n != 1 {
+		t.Errorf("first build built %d pages, want 1", n)
+	}
+	if n := build(); n != 0 {
+		t.Errorf("unchanged build built %d pages, want 0", n)
+	}
+	write("b.md", "B")
+	if n := build(); n != 2 {
+		t.Errorf("build after adding a page built %d pages, want 2", n)
+	}
+	config.Set("title", "Site")
+	if n := build(); n != 2 {
+		t.Errorf("build after a config change built %d pages, want 2", n)
+	}
+}
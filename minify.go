@@ -0,0 +1,392 @@
+package main
+
+import "strings"
+
+// Minifies CSS by removing comments and collapsing whitespace. Comments
+// starting with '/*!' are kept, as they usually hold licenses.
+func MinifyCSS(css string) string {
+	out := make([]byte, 0, len(css))
+	space := false
+
+	// writes a pending space unless the neighbouring characters make it
+	// unnecessary
+	flush := func(next byte) {
+		if space && len(out) > 0 && !strings.ContainsRune("{};,>", rune(next)) {
+			if !strings.ContainsRune("{};,>:", rune(out[len(out)-1])) {
+				out = append(out, ' ')
+			}
+		}
+		space = false
+	}
+
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case c == '/' && i+1 < len(css) && css[i+1] == '*':
+			end := strings.Index(css[i+2:], "*/") + i + 4
+			if end < i+4 {
+				end = len(css)
+			}
+			if strings.HasPrefix(css[i:], "/*!") {
+				flush('/')
+				out = append(out, css[i:end]...)
+			}
+			i = end - 1
+		case c == '"' || c == '\'':
+			flush(c)
+			end := skipString(css, i)
+			out = append(out, css[i:end]...)
+			i = end - 1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+		case c == '}':
+			space = false
+			// the last declaration of a block needs no semicolon
+			if len(out) > 0 && out[len(out)-1] == ';' {
+				out = out[:len(out)-1]
+			}
+			out = append(out, c)
+		default:
+			flush(c)
+			out = append(out, c)
+		}
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Minifies JavaScript conservatively: comments are removed (except those
+// starting with '/*!') along with indentation and blank lines, but line
+// breaks are kept so that automatic semicolon insertion is unaffected.
+func MinifyJS(js string) string {
+	var out strings.Builder
+	var line strings.Builder
+
+	endLine := func() {
+		trimmed := strings.TrimSpace(line.String())
+		if trimmed != "" {
+			out.WriteString(trimmed)
+			out.WriteByte('\n')
+		}
+		line.Reset()
+	}
+
+	for i := 0; i < len(js); i++ {
+		c := js[i]
+		switch {
+		case c == '/' && i+1 < len(js) && js[i+1] == '/':
+			for i < len(js) && js[i] != '\n' {
+				i++
+			}
+			endLine()
+		case c == '/' && i+1 < len(js) && js[i+1] == '*':
+			end := strings.Index(js[i+2:], "*/") + i + 4
+			if end < i+4 {
+				end = len(js)
+			}
+			comment := js[i:end]
+			if strings.HasPrefix(comment, "/*!") {
+				line.WriteString(comment)
+			} else if strings.Contains(comment, "\n") {
+				endLine()
+			} else if current := line.String(); len(current) > 0 && current[len(current)-1] != ' ' {
+				line.WriteByte(' ')
+			}
+			i = end - 1
+		case c == '"' || c == '\'' || c == '`':
+			end := skipString(js, i)
+			line.WriteString(js[i:end])
+			i = end - 1
+		case c == '/' && regexAllowed(line.String(), out.String()):
+			end := skipRegex(js, i)
+			line.WriteString(js[i:end])
+			i = end - 1
+		case c == '\n':
+			endLine()
+		case c == ' ' || c == '\t' || c == '\r':
+			// collapse runs of whitespace within a line
+			current := line.String()
+			if len(current) > 0 && current[len(current)-1] != ' ' {
+				line.WriteByte(' ')
+			}
+		default:
+			line.WriteByte(c)
+		}
+	}
+	endLine()
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// Returns the index just past the string literal starting at i
+func skipString(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		case '\n':
+			if quote != '`' {
+				return j
+			}
+		}
+	}
+	return len(s)
+}
+
+// Returns the index just past the regular expression literal starting at i
+func skipRegex(s string, i int) int {
+	class := false
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '/':
+			if !class {
+				// include any flags
+				for j+1 < len(s) && strings.ContainsRune("gimsuy", rune(s[j+1])) {
+					j++
+				}
+				return j + 1
+			}
+		case '\n':
+			return j
+		}
+	}
+	return len(s)
+}
+
+// Whether a '/' following the given code starts a regular expression
+// rather than a division.
+func regexAllowed(line, previous string) bool {
+	code := strings.TrimRight(line, " ")
+	if code == "" {
+		code = strings.TrimRight(previous, " \n")
+	}
+	if code == "" {
+		return true
+	}
+	if strings.ContainsRune("(,=:[!&|?{};+-*%<>~^", rune(code[len(code)-1])) {
+		return true
+	}
+	for _, keyword := range []string{"return", "typeof", "case", "do", "else", "in", "of", "void", "yield"} {
+		if strings.HasSuffix(code, keyword) {
+			before := strings.TrimSuffix(code, keyword)
+			if before == "" || !isIdentChar(before[len(before)-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// End tags which may be omitted from html documents
+var optionalEndTags = map[string]bool{
+	"li": true, "option": true, "td": true, "th": true, "tr": true,
+	"body": true, "html": true,
+}
+
+// Minifies html by collapsing whitespace, dropping comments and optional
+// end tags and minifying inline CSS and JavaScript. The contents of <pre>
+// and <textarea> elements are left untouched, as are conditional comments.
+func MinifyHTML(html string) string {
+	out := make([]byte, 0, len(html))
+	space := false
+	lower := LowerASCII(html)
+
+	for i := 0; i < len(html); {
+		c := html[i]
+
+		if c != '<' {
+			if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' {
+				space = true
+			} else {
+				if space && len(out) > 0 {
+					out = append(out, ' ')
+				}
+				space = false
+				out = append(out, c)
+			}
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(html[i:], "<!--") {
+			end := strings.Index(html[i+4:], "-->") + i + 7
+			if end < i+7 {
+				end = len(html)
+			}
+			if strings.HasPrefix(html[i:], "<!--[if") {
+				if space && len(out) > 0 {
+					out = append(out, ' ')
+				}
+				space = false
+				out = append(out, html[i:end]...)
+			}
+			i = end
+			continue
+		}
+
+		end := tagEnd(html, i)
+		tag := html[i:end]
+		name := tagName(lower[i:end])
+
+		if strings.HasPrefix(tag, "</") && optionalEndTags[name] {
+			// an omitted end tag takes its trailing whitespace with it
+			i = end
+			for i < len(html) && strings.ContainsRune(" \t\n\r\f", rune(html[i])) {
+				i++
+			}
+			continue
+		}
+
+		if space && len(out) > 0 {
+			out = append(out, ' ')
+		}
+		space = false
+		out = append(out, collapseTag(tag)...)
+		i = end
+
+		if strings.HasPrefix(tag, "</") || strings.HasSuffix(tag, "/>") {
+			continue
+		}
+		switch name {
+		case "pre", "textarea", "script", "style":
+			close := strings.Index(lower[i:], "</"+name)
+			if close < 0 {
+				close = len(html) - i
+			}
+			content := html[i : i+close]
+			switch {
+			case name == "style":
+				content = MinifyCSS(content)
+			case name == "script" && javascriptTag(lower[i-len(tag):i]):
+				content = MinifyJS(content)
+			}
+			out = append(out, content...)
+			i += close
+		}
+	}
+	return string(out)
+}
+
+// Returns the index just past the tag starting at i, respecting quoted
+// attribute values.
+func tagEnd(html string, i int) int {
+	var quote byte
+	for j := i + 1; j < len(html); j++ {
+		switch {
+		case quote != 0:
+			if html[j] == quote {
+				quote = 0
+			}
+		case html[j] == '"' || html[j] == '\'':
+			quote = html[j]
+		case html[j] == '>':
+			return j + 1
+		}
+	}
+	return len(html)
+}
+
+// Returns the lowercase element name of a tag
+func tagName(tag string) string {
+	tag = strings.TrimLeft(tag, "</!")
+	end := strings.IndexAny(tag, " \t\n\r\f/>")
+	if end < 0 {
+		return tag
+	}
+	return tag[:end]
+}
+
+// Collapses the whitespace between the attributes of a tag
+func collapseTag(tag string) string {
+	out := make([]byte, 0, len(tag))
+	var quote byte
+	space := false
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		if quote == 0 && strings.ContainsRune(" \t\n\r\f", rune(c)) {
+			space = len(out) > 0 && out[len(out)-1] != '='
+			continue
+		}
+		if space && c != '>' && c != '=' && !(c == '/' && i+1 < len(tag) && tag[i+1] == '>') {
+			out = append(out, ' ')
+		}
+		space = false
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		}
+		out = append(out, c)
+	}
+	return string(out)
+}
+
+// Whether a script tag holds JavaScript rather than data or a template
+func javascriptTag(tag string) bool {
+	kind, ok := tagAttr(tag, "type")
+	if !ok {
+		return true
+	}
+	kind = strings.TrimSpace(kind)
+	return kind == "" || kind == "module" || strings.Contains(kind, "javascript")
+}
+
+// Returns the value of the attribute of a lowercased tag with the given
+// name. Attributes without a value have an empty one.
+func tagAttr(tag, name string) (string, bool) {
+	i := strings.IndexAny(tag, " \t\r\n/>")
+	if i < 0 {
+		return "", false
+	}
+	for i < len(tag) {
+		for i < len(tag) && strings.IndexByte(" \t\r\n/>", tag[i]) >= 0 {
+			i++
+		}
+		start := i
+		for i < len(tag) && strings.IndexByte(" \t\r\n/>=", tag[i]) < 0 {
+			i++
+		}
+		attr := tag[start:i]
+		for i < len(tag) && strings.IndexByte(" \t\r\n", tag[i]) >= 0 {
+			i++
+		}
+		value := ""
+		if i < len(tag) && tag[i] == '=' {
+			i++
+			for i < len(tag) && strings.IndexByte(" \t\r\n", tag[i]) >= 0 {
+				i++
+			}
+			if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+				quote := tag[i]
+				end := strings.IndexByte(tag[i+1:], quote)
+				if end < 0 {
+					end = len(tag) - i - 1
+				}
+				value = tag[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start := i
+				for i < len(tag) && strings.IndexByte(" \t\r\n>", tag[i]) < 0 {
+					i++
+				}
+				value = tag[start:i]
+			}
+		}
+		if attr == name {
+			return value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"whitespace", "<p>\n  Hello   <b>world</b>\n</p>", "<p> Hello <b>world</b> </p>"},
+		{"comments", "<p>a<!-- note -->b</p>", "<p>ab</p>"},
+		{"conditional comments", "<!--[if IE]><p>old</p><![endif]-->", "<!--[if IE]><p>old</p><![endif]-->"},
+		{"optional end tags", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>", "<ul> <li>a<li>b</ul>"},
+		{"attributes", `<a   href="x  y"  class='c'>l</a>`, `<a href="x  y" class='c'>l</a>`},
+		{"pre", "<pre>  a\n   b</pre>", "<pre>  a\n   b</pre>"},
+		{"uppercase tags", "<PRE>  a\n   b</PRE>", "<PRE>  a\n   b</PRE>"},
+		{"style", "<style>\np { color: red; }\n</style>", "<style>p{color:red}</style>"},
+		{"script", "<script>\n  // note\n  var a = 1;\n</script>", "<script>var a = 1;</script>"},
+		{"script data", "<script type=\"text/template\">\n  <p>x</p>\n</script>", "<script type=\"text/template\">\n  <p>x</p>\n</script>"},
+		{"script data-type", "<script data-type=\"javascript\" type=\"text/html\">\n  <p>x</p>\n</script>", "<script data-type=\"javascript\" type=\"text/html\">\n  <p>x</p>\n</script>"},
+		{"script data-type only", "<script data-type=\"text/html\">\n  var a = 1;\n</script>", "<script data-type=\"text/html\">var a = 1;</script>"},
+		{"non-ascii text", "<p>İstanbul   İzmir</p>\n<pre>  a  </pre>", "<p>İstanbul İzmir</p> <pre>  a  </pre>"},
+		{"non-ascii before tags", "<p>ẞİİİ</p><style> a { b: c } </style>", "<p>ẞİİİ</p><style>a{b:c}</style>"},
+	}
+	for _, test := range tests {
+		if got := MinifyHTML(test.in); got != test.want {
+			t.Errorf("%s: MinifyHTML(%q) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"whitespace", "a  >  b {\n  color: red ;\n}\n", "a>b{color:red}"},
+		{"comments", "/* x */a{b:c}", "a{b:c}"},
+		{"license comments", "/*! MIT */\na{b:c}", "/*! MIT */ a{b:c}"},
+		{"strings", `a::after { content: "  {  } " }`, `a::after{content:"  {  } "}`},
+		{"descendant selectors", "a :hover { x: y }", "a :hover{x:y}"},
+		{"selectors", "ul li ,\nol li { margin: 0 auto }", "ul li,ol li{margin:0 auto}"},
+		{"non-ascii", `a::before { content: "İ  ü" } /* ẞ */ b { c: d }`, `a::before{content:"İ  ü"}b{c:d}`},
+	}
+	for _, test := range tests {
+		if got := MinifyCSS(test.in); got != test.want {
+			t.Errorf("%s: MinifyCSS(%q) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestMinifyJS(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"comments", "// a\nvar x = 1; // b\n/* c */\nvar y = 2;", "var x = 1;\nvar y = 2;"},
+		{"line breaks kept", "var a = 1\n\n\nvar b = 2", "var a = 1\nvar b = 2"},
+		{"strings", `var s = "a // b /* c */";`, `var s = "a // b /* c */";`},
+		{"regex", `var r = /\/\/ x/g;`, `var r = /\/\/ x/g;`},
+		{"division", "var d = a / b / c;", "var d = a / b / c;"},
+		{"license comments", "/*! MIT */\nvar a;", "/*! MIT */\nvar a;"},
+		{"non-ascii", "var s = 'İ  ü'; // ẞ\n  var t = 1;", "var s = 'İ  ü';\nvar t = 1;"},
+	}
+	for _, test := range tests {
+		if got := MinifyJS(test.in); got != test.want {
+			t.Errorf("%s: MinifyJS(%q) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestJavascriptTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{"<script>", true},
+		{"<script src=\"a.js\">", true},
+		{"<script type=\"module\">", true},
+		{"<script type='text/javascript'>", true},
+		{"<script type=application/javascript>", true},
+		{"<script type = \"text/template\">", false},
+		{"<script type=\"application/ld+json\">", false},
+		{"<script data-type=\"javascript\" type=\"text/html\">", false},
+		{"<script data-type=\"text/html\">", true},
+		{"<script title=\"type=text/html\">", true},
+		{"<script async type=\"text/html\">", false},
+	}
+	for _, test := range tests {
+		if got := javascriptTag(test.tag); got != test.want {
+			t.Errorf("javascriptTag(%q) = %v, want %v", test.tag, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "regexp"
+import "strings"
+import "time"
+
+// The built-in archetype of each kind of content
+var Archetypes = map[string]string{
+	"page": PageMD,
+	"post": PostMD,
+}
+
+// Matches the '{{ name }}' placeholders of an archetype
+var archetypePlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Returns the archetype of a kind of content: archetypes/<kind>.md of the
+// workspace, then archetypes/default.md, then the built-in one
+func (m *Manager) Archetype(kind string) (string, error) {
+	for _, name := range []string{kind + ".md", "default.md"} {
+		raw, err := ioutil.ReadFile(filepath.Join(m.Fspath, "archetypes", name))
+		if err == nil {
+			return string(raw), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return Archetypes[kind], nil
+}
+
+// Fills the placeholders of an archetype. Placeholders without a value are
+// left as they are.
+func FillArchetype(archetype string, values map[string]string) string {
+	return archetypePlaceholder.ReplaceAllStringFunc(archetype, func(placeholder string) string {
+		name := archetypePlaceholder.FindStringSubmatch(placeholder)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// Creates a page of the site from its archetype. The path names the file in
+// src/pages, the '.md' extension being optional; the file is named after its
+// slug, so that its url is clean.
+func NewPage(m *Manager, path, title string) (string, error) {
+	name := filepath.ToSlash(filepath.Clean(path))
+	name = strings.TrimPrefix(name, "src/pages/")
+	if strings.Contains(name, "/") || strings.HasPrefix(name, ".") {
+		return "", &CustomError{"pages live directly in src/pages; '" + path + "' is not a page name"}
+	}
+	slug := Slugify(strings.TrimSuffix(name, ".md"))
+	if slug == "" {
+		return "", &CustomError{"'" + path + "' is not a page name"}
+	}
+	if title == "" {
+		return "", &CustomError{"a page needs a title"}
+	}
+	return m.newContent("page", filepath.Join(m.Fspath, "src", "pages", slug+".md"), title, slug)
+}
+
+// Creates a post from its archetype, named after the date and the title.
+// Posts are built from src/pages like any page, with the post layout.
+func NewPost(m *Manager, title string) (string, error) {
+	slug := Slugify(title)
+	if slug == "" {
+		return "", &CustomError{"a post needs a title"}
+	}
+	name := time.Now().Format("2006-01-02") + "-" + slug + ".md"
+	return m.newContent("post", filepath.Join(m.Fspath, "src", "pages", name), title, slug)
+}
+
+// Writes a new content file from the archetype of its kind, refusing to
+// replace an existing file
+func (m *Manager) newContent(kind, path, title, slug string) (string, error) {
+	if Exists(path) {
+		return "", &CustomError{"'" + path + "' already exists"}
+	}
+	archetype, err := m.Archetype(kind)
+	if err != nil {
+		return "", err
+	}
+	contents := FillArchetype(archetype, map[string]string{
+		"title":  title,
+		"slug":   slug,
+		"date":   time.Now().Format("2006-01-02"),
+		"author": m.Site.Author,
+		"layout": kind,
+	})
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	_, err = file.WriteString(contents)
+	return path, err
+}
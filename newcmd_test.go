@@ -0,0 +1,55 @@
+package main
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "testing"
+
+func TestFillArchetype(t *testing.T) {
+	got := FillArchetype("title: {{ title }}\nslug: {{slug}}\nother: {{ other }}\n", map[string]string{"title": "Über", "slug": "uber"})
+	want := "title: Über\nslug: uber\nother: {{ other }}\n"
+	if got != want {
+		t.Errorf("FillArchetype = %q, want %q", got, want)
+	}
+}
+
+func TestNewPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := &Manager{Fspath: dir, Site: DefaultSiteConfig()}
+	m.Site.Author = "Ann"
+
+	tests := []struct {
+		path string
+		file string
+		slug string
+	}{
+		{"about", "about.md", "about"},
+		{"About Us", "about-us.md", "about-us"},
+		{"src/pages/Contact.md", "contact.md", "contact"},
+	}
+	for _, test := range tests {
+		name, err := NewPage(m, test.path, "Title")
+		if err != nil {
+			t.Errorf("NewPage(%q): %s", test.path, err)
+			continue
+		}
+		if want := filepath.Join(dir, "src", "pages", test.file); name != want {
+			t.Errorf("NewPage(%q) wrote %s, want %s", test.path, name, want)
+		}
+		raw, _ := ioutil.ReadFile(name)
+		if !strings.Contains(string(raw), "\nslug: "+test.slug+"\n") || !strings.Contains(string(raw), "\nauthor: Ann\n") {
+			t.Errorf("NewPage(%q) wrote %q", test.path, raw)
+		}
+	}
+
+	for _, path := range []string{"about", "a/b", ".hidden", "!!!"} {
+		if _, err := NewPage(m, path, "Title"); err == nil {
+			t.Errorf("NewPage(%q) succeeded", path)
+		}
+	}
+}
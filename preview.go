@@ -0,0 +1,256 @@
+package main
+
+import "bytes"
+import "net/http"
+import "os"
+import "path"
+import "strconv"
+import "strings"
+import "sync"
+import "time"
+
+// Renders the pages of a site on request instead of serving its 'build'
+// directory, which is never touched. The site is loaded once and loaded
+// again whenever its workspace changes, so that edits show immediately,
+// and drafts are rendered too.
+type PreviewHandler struct {
+	Builder *Builder
+	Headers map[string]string
+
+	// template engines are not safe for concurrent use, and requests share
+	// the loaded site
+	mutex sync.Mutex
+
+	// whether the workspace changed since the site was loaded
+	stale  bool
+	loaded bool
+	// the error loading the site, until the workspace changes
+	err error
+
+	pages      map[string]*Page
+	redirector *Redirector
+	rules      HeaderRules
+}
+
+// Returns a preview handler for a site, watching its workspace for changes
+func NewPreviewHandler(manager *Manager, headers map[string]string) (*PreviewHandler, error) {
+	builder := NewBuilder(manager, false)
+	builder.Drafts = true
+	h := &PreviewHandler{Builder: builder, Headers: headers, stale: true}
+	err := WatchWorkspace(manager.Fspath, func(map[string]bool) {
+		h.mutex.Lock()
+		h.stale = true
+		h.mutex.Unlock()
+	})
+	if err != nil {
+		return nil, &CustomError{"cannot watch the workspace: " + err.Error()}
+	}
+	return h, nil
+}
+
+func (h *PreviewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	for name, value := range h.Headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if serve := h.route(w, r); serve != nil {
+		serve()
+	}
+}
+
+// Responds to a request from the loaded site. Static files and assets are
+// not served under the lock: the function serving them is returned instead.
+func (h *PreviewHandler) route(w http.ResponseWriter, r *http.Request) func() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	urlpath := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") && urlpath != "/" {
+		urlpath += "/"
+	}
+
+	err := h.load()
+	if err != nil {
+		// static files are still served while the site is broken
+		if serve := h.static(w, r, urlpath); serve != nil {
+			return serve
+		}
+		h.fail(w, err)
+		return nil
+	}
+	h.rules.Apply(w.Header(), r.URL.Path)
+
+	page, redirect := h.findPage(urlpath)
+	if redirect != "" {
+		http.Redirect(w, r, redirect, http.StatusMovedPermanently)
+		return nil
+	}
+	if page != nil {
+		h.servePage(w, r, page, http.StatusOK)
+		return nil
+	}
+
+	// as in builds, redirects never replace pages
+	if h.redirector.Redirect(w, r) {
+		return nil
+	}
+
+	if serve := h.static(w, r, urlpath); serve != nil {
+		return serve
+	}
+
+	page, _ = h.findPage("/404.html")
+	if page == nil {
+		page, _ = h.Builder.NotFoundPage()
+	}
+	if page == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	h.servePage(w, r, page, http.StatusNotFound)
+	return nil
+}
+
+// Returns the function serving the asset or static file at a url path, if
+// there is one
+func (h *PreviewHandler) static(w http.ResponseWriter, r *http.Request, urlpath string) func() {
+	m := h.Builder.Manager
+	if m.Assets != nil && strings.HasPrefix(urlpath, "/static/") {
+		if raw, ok := m.Assets.Content(strings.TrimPrefix(urlpath, "/static/")); ok {
+			return func() {
+				http.ServeContent(w, r, path.Base(urlpath), time.Time{}, bytes.NewReader(raw))
+			}
+		}
+	}
+	if m.Theme == nil {
+		return nil
+	}
+	name, ok := m.FindStatic(urlpath)
+	if !ok {
+		return nil
+	}
+	fi, err := os.Stat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	return func() {
+		file, err := os.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), file)
+	}
+}
+
+// Loads the site unless it is loaded and its workspace did not change
+// since. An error loading the site is kept until the workspace changes.
+func (h *PreviewHandler) load() error {
+	if !h.stale {
+		return h.err
+	}
+	h.stale = false
+	h.err = h.reload()
+	return h.err
+}
+
+// Reads the configuration, theme, pages, assets, header rules and
+// redirects of the site
+func (h *PreviewHandler) reload() error {
+	// the manager the handler was created with is freshly loaded
+	if h.loaded {
+		err := h.Builder.Reload()
+		if err != nil {
+			return err
+		}
+	}
+	h.loaded = true
+
+	err := h.Builder.Load()
+	if err != nil {
+		return err
+	}
+	m := h.Builder.Manager
+	m.Assets = NewAssetPipeline(m)
+	err = m.Assets.Preview()
+	if err != nil {
+		return err
+	}
+	rules, err := m.HeaderRules()
+	if err != nil {
+		return err
+	}
+	redirects, err := h.Builder.Redirects()
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[string]*Page)
+	for _, fi := range m.Pages {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".md") {
+			continue
+		}
+		page, err := m.LoadPage(fi)
+		if err != nil {
+			return err
+		}
+		pages[page.Permalink()] = &page
+	}
+	h.pages, h.redirector, h.rules = pages, NewRedirector(redirects), rules
+	return nil
+}
+
+// Returns the page built to a url path. Paths without an extension match
+// the '.html' page of the same name, and directory paths their index page.
+// Returns the url to redirect to instead for a directory path lacking its
+// trailing slash.
+func (h *PreviewHandler) findPage(urlpath string) (*Page, string) {
+	candidates := []string{urlpath}
+	if path.Ext(urlpath) == "" {
+		candidates = append(candidates, urlpath+".html")
+	}
+	if strings.HasSuffix(urlpath, "/") {
+		candidates = append(candidates, urlpath+"index.html")
+	}
+	for _, candidate := range candidates {
+		if page, ok := h.pages[candidate]; ok {
+			return page, ""
+		}
+	}
+	if _, ok := h.pages[urlpath+"/"]; ok {
+		return nil, urlpath + "/"
+	}
+	return nil, ""
+}
+
+// Renders a page and writes it with the given status
+func (h *PreviewHandler) servePage(w http.ResponseWriter, r *http.Request, page *Page, status int) {
+	out, err := h.Builder.RenderPage(page)
+	if err != nil {
+		h.fail(w, &CustomError{"could not render " + page.Fi.Name() + ": " + err.Error()})
+		return
+	}
+	if h.Builder.Manager.Site.MinifyHTML {
+		out = MinifyHTML(out)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		w.Write([]byte(out))
+	}
+}
+
+// Reports an error of the site both in the log and to the browser
+func (h *PreviewHandler) fail(w http.ResponseWriter, err error) {
+	OUT.Errorf("%s", err)
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,293 @@
+package main
+
+import "bytes"
+import "fmt"
+import "html"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "os"
+import "path"
+import "path/filepath"
+import "strconv"
+import "strings"
+
+// A rule redirecting requests for a path to another url
+type Redirect struct {
+	From   string
+	To     string
+	Status int
+}
+
+// The statuses a redirect rule may use
+var redirectStatuses = map[int]bool{301: true, 302: true, 307: true, 308: true}
+
+// Parses redirect rules, one per line as 'from to [status]'. The status
+// defaults to 301. Blank lines and lines starting with '#' are ignored.
+// Splats and placeholders are refused, as stubs are written for plain
+// paths only.
+func ParseRedirects(raw string) ([]Redirect, error) {
+	redirects := make([]Redirect, 0)
+	for i, line := range strings.Split(strings.Replace(raw, "\r\n", "\n", -1), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, &CustomError{fmt.Sprintf("line %d: expected 'from to [status]'", i+1)}
+		}
+		if !strings.HasPrefix(fields[0], "/") {
+			return nil, &CustomError{fmt.Sprintf("line %d: '%s' is not a root-relative path", i+1, fields[0])}
+		}
+		for _, field := range fields[:2] {
+			if segment, ok := placeholderSegment(field); ok {
+				return nil, &CustomError{fmt.Sprintf("line %d: '%s' in '%s' is a pattern; only plain paths are redirected", i+1, segment, field)}
+			}
+		}
+		redirect := Redirect{From: fields[0], To: fields[1], Status: 301}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			if err != nil || !redirectStatuses[status] {
+				return nil, &CustomError{fmt.Sprintf("line %d: '%s' is not a redirect status", i+1, fields[2])}
+			}
+			redirect.Status = status
+		}
+		redirects = append(redirects, redirect)
+	}
+	return redirects, nil
+}
+
+// Returns the first segment of a root-relative path which is a splat or a
+// placeholder, such as '/blog/*' or '/posts/:slug', as other hosts write
+// their redirect patterns
+func placeholderSegment(urlpath string) (string, bool) {
+	if !strings.HasPrefix(urlpath, "/") {
+		return "", false
+	}
+	for _, segment := range strings.Split(urlpath, "/") {
+		if strings.Contains(segment, "*") || strings.HasPrefix(segment, ":") {
+			return segment, true
+		}
+	}
+	return "", false
+}
+
+// Returns the key a path is matched on: cleaned, without trailing slash
+func redirectKey(urlpath string) string {
+	clean := path.Clean("/" + urlpath)
+	if clean != "/" {
+		clean = strings.TrimSuffix(clean, "/")
+	}
+	return clean
+}
+
+// Returns the redirect rules of the site: those of the workspace 'redirects'
+// file, then one for every alias of a page, leading to the page.
+func (b *Builder) Redirects() ([]Redirect, error) {
+	m := b.Manager
+	redirects := make([]Redirect, 0)
+
+	name := filepath.Join(m.Fspath, "redirects")
+	if Exists(name) {
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		redirects, err = ParseRedirects(string(raw))
+		if err != nil {
+			return nil, &CustomError{"invalid redirects file: " + err.Error()}
+		}
+	}
+
+	for _, fi := range m.Pages {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".md") {
+			continue
+		}
+		page, err := m.LoadPage(fi)
+		if err != nil {
+			return nil, err
+		}
+		if page.Draft && !b.drafts() {
+			continue
+		}
+		for _, alias := range page.Aliases {
+			if !strings.HasPrefix(alias, "/") {
+				alias = "/" + alias
+			}
+			redirects = append(redirects, Redirect{From: alias, To: page.Permalink(), Status: 301})
+		}
+	}
+	return redirects, nil
+}
+
+// Writes a meta refresh page at the old path of every redirect, and the
+// '_redirects' manifest listing all of them for hosts which redirect on the
+// server. Rules which would replace a page or a static file are ignored,
+// and stubs of removed rules are deleted; those written are recorded in the
+// workspace '.goblinredirects' file.
+func (b *Builder) BuildRedirects() error {
+	m := b.Manager
+	redirects, err := b.Redirects()
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[string]bool)
+	for _, fi := range m.Pages {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".md") {
+			continue
+		}
+		page, err := m.LoadPage(fi)
+		if err != nil {
+			return err
+		}
+		pages[b.OutputName(&page)] = true
+	}
+	files, _, err := m.StaticFiles()
+	if err != nil {
+		return err
+	}
+	static := make(map[string]bool)
+	for dest := range files {
+		static[filepath.Join(m.Fspath, "build", dest)] = true
+	}
+
+	records := NewConfig(filepath.Join(m.Fspath, ".goblinredirects"))
+	if Exists(records.filename) {
+		err = records.parse()
+		if err != nil {
+			return err
+		}
+	}
+	stubs := NewConfig(records.filename)
+
+	var manifest bytes.Buffer
+	for _, redirect := range redirects {
+		stub := b.stubName(redirect.From)
+		if pages[stub] {
+			OUT.Infof("warning: ignoring the redirect from '%s', which would replace a page", redirect.From)
+			continue
+		}
+		if static[stub] {
+			OUT.Infof("warning: ignoring the redirect from '%s', which would replace a static file", redirect.From)
+			continue
+		}
+		fmt.Fprintf(&manifest, "%s %s %d\n", redirect.From, redirect.To, redirect.Status)
+		rel, _ := filepath.Rel(m.Fspath, stub)
+		stubs.Set(filepath.ToSlash(rel), redirect.To)
+
+		err = os.MkdirAll(filepath.Dir(stub), 0755)
+		if err != nil {
+			return err
+		}
+		err = writeIfChanged(stub, []byte(redirectStub(m.Site.Url, redirect.To)))
+		if err != nil {
+			return err
+		}
+	}
+
+	for rel := range records.data {
+		if _, ok := stubs.data[rel]; ok {
+			continue
+		}
+		stub := filepath.Join(m.Fspath, filepath.FromSlash(rel))
+		if pages[stub] || static[stub] {
+			continue
+		}
+		err = os.Remove(stub)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removeEmptyDirs(filepath.Dir(stub), filepath.Join(m.Fspath, "build"))
+	}
+
+	err = writeIfChanged(filepath.Join(m.Fspath, "build", "_redirects"), manifest.Bytes())
+	if err != nil {
+		return err
+	}
+	return SaveConfig(stubs)
+}
+
+// Returns the file of the build directory the stub of a redirect from the
+// given path is written to. Paths without an extension are directories.
+func (b *Builder) stubName(from string) string {
+	clean := path.Clean("/" + from)
+	name := filepath.Join(b.Manager.Fspath, "build", filepath.FromSlash(clean))
+	if strings.HasSuffix(from, "/") || path.Ext(clean) == "" {
+		return filepath.Join(name, "index.html")
+	}
+	return name
+}
+
+// Removes dir and its parents up to root as long as they are empty
+func removeEmptyDirs(dir, root string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Returns the html of a page redirecting browsers to a url
+func redirectStub(siteurl, to string) string {
+	target := html.EscapeString(RelURL(siteurl, to))
+	canonical := html.EscapeString(AbsURL(siteurl, to))
+	return `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Redirecting&hellip;</title>
+<link rel="canonical" href="` + canonical + `">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url=` + target + `">
+</head>
+<body>
+<p>This page has moved to <a href="` + target + `">` + canonical + `</a>.</p>
+</body>
+</html>
+`
+}
+
+// Writes a file unless it already has the given content, so that unchanged
+// outputs keep their modification time
+func writeIfChanged(name string, content []byte) error {
+	if current, err := ioutil.ReadFile(name); err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+	return ioutil.WriteFile(name, content, 0644)
+}
+
+// Redirects requests according to a set of rules
+type Redirector struct {
+	rules map[string]Redirect
+}
+
+func NewRedirector(redirects []Redirect) *Redirector {
+	rd := &Redirector{rules: make(map[string]Redirect)}
+	for _, redirect := range redirects {
+		// the first rule for a path wins
+		key := redirectKey(redirect.From)
+		if _, ok := rd.rules[key]; !ok {
+			rd.rules[key] = redirect
+		}
+	}
+	return rd
+}
+
+// Answers a request with a redirect if a rule matches its path. Returns
+// whether a response was written.
+func (rd *Redirector) Redirect(w http.ResponseWriter, r *http.Request) bool {
+	redirect, ok := rd.rules[redirectKey(r.URL.Path)]
+	if !ok {
+		return false
+	}
+	target := redirect.To
+	if r.URL.RawQuery != "" {
+		if ref, err := url.Parse(target); err == nil && ref.RawQuery == "" {
+			target += "?" + r.URL.RawQuery
+		}
+	}
+	http.Redirect(w, r, target, redirect.Status)
+	return true
+}
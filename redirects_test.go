@@ -0,0 +1,140 @@
+package main
+
+import "io/ioutil"
+import "net/http/httptest"
+import "os"
+import "path/filepath"
+import "reflect"
+import "strings"
+import "testing"
+
+func TestParseRedirects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Redirect
+		err  bool
+	}{
+		{"empty", "", []Redirect{}, false},
+		{"default status", "/old /new", []Redirect{{"/old", "/new", 301}}, false},
+		{"status", "/old /new 302", []Redirect{{"/old", "/new", 302}}, false},
+		{"comments and blank lines", "# moved\n\n  /a   /b  \r\n/c https://example.com/ 308\n",
+			[]Redirect{{"/a", "/b", 301}, {"/c", "https://example.com/", 308}}, false},
+		{"non-ascii paths", "/café /über 307", []Redirect{{"/café", "/über", 307}}, false},
+		{"missing target", "/old", nil, true},
+		{"too many fields", "/a /b 301 x", nil, true},
+		{"relative path", "old /new", nil, true},
+		{"invalid status", "/a /b 200", nil, true},
+		{"status not a number", "/a /b moved", nil, true},
+		{"splat", "/blog/* /news/ 301", nil, true},
+		{"splat in a segment", "/blog/page* /news/", nil, true},
+		{"placeholder", "/posts/:slug /articles/", nil, true},
+		{"placeholder in target", "/posts/ /articles/:splat", nil, true},
+		{"colon inside a segment", "/a:b /c", []Redirect{{"/a:b", "/c", 301}}, false},
+		{"absolute target", "/a https://example.com:8080/x", []Redirect{{"/a", "https://example.com:8080/x", 301}}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseRedirects(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("%s: ParseRedirects(%q) error = %v, want error %v", test.name, test.in, err, test.err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: ParseRedirects(%q) = %v, want %v", test.name, test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseRedirectsPatternLine(t *testing.T) {
+	_, err := ParseRedirects("/old /new\n/blog/* /news/\n")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ParseRedirects of a splat on line 2 = %v, want an error naming line 2", err)
+	}
+}
+
+func TestRedirector(t *testing.T) {
+	rd := NewRedirector([]Redirect{
+		{"/old/", "/new/", 301},
+		{"/old", "/other/", 302},
+		{"/temp", "/elsewhere", 307},
+	})
+	tests := []struct {
+		url      string
+		status   int
+		location string
+	}{
+		{"/old", 301, "/new/"},
+		{"/old/", 301, "/new/"},
+		{"/temp", 307, "/elsewhere"},
+		{"/missing", 0, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		redirected := rd.Redirect(w, httptest.NewRequest("GET", test.url, nil))
+		if !redirected {
+			if test.status != 0 {
+				t.Errorf("%s: not redirected, want %d", test.url, test.status)
+			}
+			continue
+		}
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Errorf("%s: redirected %d to %q, want %d to %q", test.url, w.Code, w.Header().Get("Location"), test.status, test.location)
+		}
+	}
+}
+
+func TestIsManifest(t *testing.T) {
+	for url, want := range map[string]bool{
+		"/_redirects":      true,
+		"/_headers":        true,
+		"/_Headers":        true,
+		"/a/../_redirects": true,
+		"/_redirects.html": false,
+		"/blog/_redirects": false,
+		"/":                false,
+	} {
+		if got := isManifest(url); got != want {
+			t.Errorf("isManifest(%q) = %v, want %v", url, got, want)
+		}
+	}
+}
+
+func TestBuildRedirectsKeepsStaticFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goblin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{filepath.Join("src", "static", "old.html"), filepath.Join("build", "old.html")} {
+		err = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("static"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "redirects"), []byte("/old.html /new/\n/gone/ /new/\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{Fspath: dir, Site: DefaultSiteConfig(), Theme: &Theme{Name: "none", Path: filepath.Join(dir, "themes", "none")}}
+	err = NewBuilder(m, false).BuildRedirects()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if raw, _ := ioutil.ReadFile(filepath.Join(dir, "build", "old.html")); string(raw) != "static" {
+		t.Errorf("redirect replaced the static file with %q", raw)
+	}
+	if !Exists(filepath.Join(dir, "build", "gone", "index.html")) {
+		t.Errorf("no stub written for the redirect from /gone/")
+	}
+	manifest, _ := ioutil.ReadFile(filepath.Join(dir, "build", "_redirects"))
+	if string(manifest) != "/gone/ /new/ 301\n" {
+		t.Errorf("_redirects = %q, want only the redirect from /gone/", manifest)
+	}
+}
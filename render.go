@@ -1,6 +1,5 @@
 package main
 
-import "github.com/flosch/pongo"
 import "github.com/russross/blackfriday"
 
 func RenderMarkdown(content string) string {
@@ -23,9 +22,14 @@ func RenderMarkdown(content string) string {
 	return string(blackfriday.Markdown([]byte(content), renderer, extensions))
 }
 
-func RenderTheme(path string, context *pongo.Context) string {
-    template := pongo.Must(pongo.FromFile(path, nil))
-    out, err := template.Execute(context)
-    if err != nil { panic(err) }
-    return *out
+// Renders a layout of the site with the template engine of its theme. 
+// Layouts in the workspace 'layouts' directory take precedence over those of 
+// the theme and its parents.
+func RenderTheme(m *Manager, layout string, context TemplateContext) (string, error) {
+    if m.engine == nil {
+        engine, err := NewTemplateEngine(m)
+        if err != nil { return "", err }
+        m.engine = engine
+    }
+    return m.engine.Render(layout, context)
 }
@@ -0,0 +1,364 @@
+package main
+
+import "bytes"
+import "context"
+import "io/ioutil"
+import "net/http"
+import "os"
+import "os/signal"
+import "path"
+import "path/filepath"
+import "strconv"
+import "strings"
+import "sync"
+import "syscall"
+import "time"
+
+// Headers sent with every response of the server unless a header rule
+// overrides them. A header set to an empty string by a rule is not sent.
+var DefaultServerHeaders = map[string]string{
+	"Cache-Control":          "no-cache",
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "SAMEORIGIN",
+	"Referrer-Policy":        "strict-origin-when-cross-origin",
+}
+
+// Returns the headers the server sends with every response before applying
+// the header rules of the site, which include the server_headers config
+func (m *Manager) ServerHeaders() map[string]string {
+	headers := make(map[string]string)
+	for name, value := range DefaultServerHeaders {
+		headers[name] = value
+	}
+	return headers
+}
+
+// Serves the files of a build directory, preferring precompressed siblings
+// of a file when the client accepts their encoding. Extension-less urls
+// resolve to '.html' files, directories are never listed and missing paths
+// are answered with the site's '404.html' page. Requests are redirected and
+// responses given headers according to the '_redirects' and '_headers'
+// manifests of the build.
+type SiteHandler struct {
+	Root    string
+	Headers map[string]string
+
+	// When set, pages are served uncompressed with the live reload script
+	// injected
+	LiveReload *LiveReload
+
+	redirects *manifest
+	rules     *manifest
+}
+
+func NewSiteHandler(root string, headers map[string]string) *SiteHandler {
+	return &SiteHandler{
+		Root:    root,
+		Headers: headers,
+		redirects: &manifest{name: filepath.Join(root, "_redirects"), parse: func(raw string) (interface{}, error) {
+			redirects, err := ParseRedirects(raw)
+			return NewRedirector(redirects), err
+		}},
+		rules: &manifest{name: filepath.Join(root, "_headers"), parse: func(raw string) (interface{}, error) {
+			return ParseHeaders(raw)
+		}},
+	}
+}
+
+func (h *SiteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.LiveReload != nil && r.URL.Path == LiveReloadPath {
+		h.LiveReload.ServeHTTP(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	for name, value := range h.Headers {
+		w.Header().Set(name, value)
+	}
+	if isManifest(r.URL.Path) {
+		h.notFound(w, r)
+		return
+	}
+	if rules, ok := h.rules.load().(HeaderRules); ok {
+		rules.Apply(w.Header(), r.URL.Path)
+	}
+	if redirector, ok := h.redirects.load().(*Redirector); ok && redirector.Redirect(w, r) {
+		return
+	}
+
+	name, redirect, ok := h.resolve(r.URL.Path)
+	if !ok {
+		h.notFound(w, r)
+		return
+	}
+	if redirect != "" {
+		if r.URL.RawQuery != "" {
+			redirect += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, redirect, http.StatusMovedPermanently)
+		return
+	}
+
+	if h.LiveReload != nil && filepath.Ext(name) == ".html" {
+		h.servePage(w, r, name, http.StatusOK)
+		return
+	}
+	if h.serveEncoded(w, r, name) {
+		return
+	}
+	h.serveFile(w, r, name)
+}
+
+// The manifests of the build directory, which are read by the server and
+// static hosts but never served
+var Manifests = []string{"_redirects", "_headers"}
+
+// Whether a url path refers to a manifest of the build directory
+func isManifest(urlpath string) bool {
+	clean := path.Clean("/" + urlpath)
+	for _, name := range Manifests {
+		if strings.EqualFold(clean, "/"+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the file a url path refers to and whether it exists, or the url
+// to redirect to instead. Directories resolve to their index page, and
+// paths without an extension to the '.html' file of the same name.
+func (h *SiteHandler) resolve(urlpath string) (string, string, bool) {
+	clean := path.Clean("/" + urlpath)
+	name := filepath.Join(h.Root, filepath.FromSlash(clean))
+
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		index := filepath.Join(name, "index.html")
+		if !Exists(index) {
+			return "", "", false
+		}
+		if !strings.HasSuffix(urlpath, "/") {
+			return "", strings.TrimSuffix(clean, "/") + "/", true
+		}
+		return index, "", true
+	}
+	if err == nil {
+		return name, "", true
+	}
+	if path.Ext(clean) == "" {
+		if fi, err := os.Stat(name + ".html"); err == nil && !fi.IsDir() {
+			return name + ".html", "", true
+		}
+	}
+	return "", "", false
+}
+
+// Answers a request for a missing path with the site's 404 page
+func (h *SiteHandler) notFound(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Join(h.Root, "404.html")
+	if !Exists(name) {
+		http.NotFound(w, r)
+		return
+	}
+	h.servePage(w, r, name, http.StatusNotFound)
+}
+
+// Serves an html page uncompressed with the given status, injecting the
+// live reload script in watch mode
+func (h *SiteHandler) servePage(w http.ResponseWriter, r *http.Request, name string, status int) {
+	html, err := ioutil.ReadFile(name)
+	if err != nil {
+		http.Error(w, "cannot read page", http.StatusInternalServerError)
+		return
+	}
+	if h.LiveReload != nil {
+		html = InjectLiveReload(html)
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if status == http.StatusOK {
+		fi, err := os.Stat(name)
+		if err == nil {
+			http.ServeContent(w, r, filepath.Base(name), fi.ModTime(), bytes.NewReader(html))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(html)))
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		w.Write(html)
+	}
+}
+
+// Serves a file as is
+func (h *SiteHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	file, err := os.Open(name)
+	if err != nil {
+		h.notFound(w, r)
+		return
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		http.Error(w, "cannot read file", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), file)
+}
+
+// Serves a precompressed sibling of a file, if there is one the client
+// accepts. Returns whether a response was written.
+func (h *SiteHandler) serveEncoded(w http.ResponseWriter, r *http.Request, name string) bool {
+	accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	for _, encoding := range Encodings {
+		if !accepted[encoding.Name] {
+			continue
+		}
+		file, err := os.Open(name + encoding.Ext)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+		ei, err := file.Stat()
+		if err != nil {
+			return false
+		}
+
+		w.Header().Set("Content-Encoding", encoding.Name)
+		w.Header().Add("Vary", "Accept-Encoding")
+		// the content type is detected from the name of the original file
+		http.ServeContent(w, r, filepath.Base(name), ei.ModTime(), file)
+		return true
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	return false
+}
+
+// Parses an Accept-Encoding header into the set of acceptable encodings
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		if name != "" {
+			accepted[name] = q > 0
+		}
+	}
+	return accepted
+}
+
+// A manifest of the build directory, parsed again whenever it changes
+type manifest struct {
+	name  string
+	parse func(raw string) (interface{}, error)
+
+	mutex   sync.Mutex
+	modtime time.Time
+	value   interface{}
+}
+
+// Returns the parsed manifest, or nil if there is none or it is invalid
+func (mf *manifest) load() interface{} {
+	mf.mutex.Lock()
+	defer mf.mutex.Unlock()
+	fi, err := os.Stat(mf.name)
+	if err != nil {
+		mf.value = nil
+		return nil
+	}
+	if !fi.ModTime().Equal(mf.modtime) {
+		mf.modtime = fi.ModTime()
+		raw, err := ioutil.ReadFile(mf.name)
+		if err == nil {
+			mf.value, err = mf.parse(string(raw))
+		}
+		if err != nil {
+			OUT.Errorf("invalid manifest '%s': %s", mf.name, err)
+			mf.value = nil
+		}
+	}
+	return mf.value
+}
+
+// Records the status and size of a response for logging
+type loggedResponse struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (lr *loggedResponse) WriteHeader(status int) {
+	if lr.status == 0 {
+		lr.status = status
+	}
+	lr.ResponseWriter.WriteHeader(status)
+}
+
+func (lr *loggedResponse) Write(data []byte) (int, error) {
+	if lr.status == 0 {
+		lr.status = http.StatusOK
+	}
+	n, err := lr.ResponseWriter.Write(data)
+	lr.size += n
+	return n, err
+}
+
+func (lr *loggedResponse) Flush() {
+	if flusher, ok := lr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Logs every request handled by a handler
+func LogRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lr := &loggedResponse{ResponseWriter: w}
+		handler.ServeHTTP(lr, r)
+		if lr.status == 0 {
+			lr.status = http.StatusOK
+		}
+		OUT.Infof("%s %s %d %dB %s", r.Method, r.URL.RequestURI(), lr.status, lr.size, time.Since(start).Round(time.Microsecond))
+	})
+}
+
+// Runs a server until it fails or the process is interrupted, in which case
+// the server is shut down gracefully, letting pending requests complete.
+// The server uses HTTPS, and HTTP/2 with clients supporting it, when given
+// the files of a certificate and its key.
+func Serve(server *http.Server, certfile, keyfile string) error {
+	errs := make(chan error, 1)
+	go func() {
+		if certfile != "" {
+			errs <- server.ListenAndServeTLS(certfile, keyfile)
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-signals:
+	}
+
+	OUT.Infof("shutting down the server")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
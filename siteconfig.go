@@ -0,0 +1,173 @@
+package main
+
+import "encoding/json"
+import "fmt"
+import "image/jpeg"
+import "net/url"
+import "reflect"
+import "sort"
+import "strings"
+
+// The configuration of a site, decoded from the workspace config file
+type SiteConfig struct {
+	Title     string `json:"title"`
+	Url       string `json:"url"`
+	Author    string `json:"author"`
+	Copyright string `json:"copyright"`
+	Theme     string `json:"theme"`
+
+	// Whether pages marked as drafts are built
+	Drafts bool `json:"drafts"`
+
+	// Values of the parameters declared by the theme, checked against their
+	// declarations when the theme is loaded
+	ThemeParams map[string]interface{} `json:"theme_params"`
+
+	ImageWidths  []int  `json:"image_widths"`
+	ImageQuality int    `json:"image_quality"`
+	ImageSizes   string `json:"image_sizes"`
+
+	StaticIgnore []string `json:"static_ignore"`
+
+	AssetBundles     map[string][]string `json:"asset_bundles"`
+	AssetMinify      bool                `json:"asset_minify"`
+	AssetFingerprint bool                `json:"asset_fingerprint"`
+	MinifyHTML       bool                `json:"minify_html"`
+
+	CompressGzip    bool `json:"compress_gzip"`
+	CompressBrotli  bool `json:"compress_brotli"`
+	CompressMinSize int  `json:"compress_min_size"`
+
+	ServerHeaders map[string]string                 `json:"server_headers"`
+	Headers       map[string]map[string]interface{} `json:"headers"`
+}
+
+// Returns the configuration of a site which configures nothing
+func DefaultSiteConfig() *SiteConfig {
+	return &SiteConfig{
+		Copyright:        "Copyright %d",
+		Theme:            "default",
+		ImageQuality:     jpeg.DefaultQuality,
+		ImageSizes:       "100vw",
+		StaticIgnore:     append([]string{}, DefaultStaticIgnore...),
+		AssetMinify:      true,
+		AssetFingerprint: true,
+		CompressMinSize:  1024,
+	}
+}
+
+// Decodes the site configuration from a config, over the defaults. Returns
+// warnings for the keys it does not know, except custom sections holding
+// objects or lists, and an error naming the key of the first value which has
+// the wrong type or is invalid.
+func NewSiteConfig(config *Config) (*SiteConfig, []string, error) {
+	site := DefaultSiteConfig()
+	fields := siteConfigFields()
+	warnings := make([]string, 0)
+
+	keys := make([]string, 0, len(config.data))
+	for key := range config.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		index, ok := fields[key]
+		if !ok {
+			// objects and lists are custom sections for templates
+			switch config.data[key].(type) {
+			case map[string]interface{}, []interface{}:
+			default:
+				warnings = append(warnings, fmt.Sprintf("unknown config key '%s'", key))
+			}
+			continue
+		}
+		field := reflect.ValueOf(site).Elem().Field(index)
+		raw, err := json.Marshal(config.data[key])
+		if err != nil {
+			return nil, warnings, &CustomError{fmt.Sprintf("config key '%s': %s", key, err)}
+		}
+		value := reflect.New(field.Type())
+		if json.Unmarshal(raw, value.Interface()) != nil {
+			return nil, warnings, &CustomError{fmt.Sprintf("config key '%s' must be %s", key, describeType(field.Type()))}
+		}
+		field.Set(value.Elem())
+	}
+
+	err := site.Validate()
+	if err != nil {
+		return nil, warnings, err
+	}
+	return site, warnings, nil
+}
+
+// Maps the json keys of the site configuration to their field indices
+func siteConfigFields() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(SiteConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		fields[key] = i
+	}
+	return fields
+}
+
+// Describes the json values a go type is decoded from
+func describeType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int:
+		return "an integer"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "a list of " + pluralType(t.Elem())
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Interface {
+			return "an object"
+		}
+		return "an object of " + pluralType(t.Elem())
+	}
+	return "a value"
+}
+
+func pluralType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "strings"
+	case reflect.Int:
+		return "integers"
+	case reflect.Bool:
+		return "booleans"
+	case reflect.Slice:
+		return "lists of " + pluralType(t.Elem())
+	case reflect.Map:
+		return "objects"
+	}
+	return "values"
+}
+
+// Checks the values of the configuration
+func (site *SiteConfig) Validate() error {
+	if site.Url != "" {
+		if _, err := url.Parse(site.Url); err != nil {
+			return &CustomError{"config key 'url' must be a url: " + err.Error()}
+		}
+	}
+	if site.Theme == "" {
+		return &CustomError{"config key 'theme' must name a theme"}
+	}
+	for _, width := range site.ImageWidths {
+		if width <= 0 {
+			return &CustomError{"config key 'image_widths' must only hold positive widths"}
+		}
+	}
+	if site.ImageQuality < 1 || site.ImageQuality > 100 {
+		return &CustomError{"config key 'image_quality' must be between 1 and 100"}
+	}
+	if site.CompressMinSize < 0 {
+		return &CustomError{"config key 'compress_min_size' must not be negative"}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import "crypto/sha1"
+import "encoding/hex"
+import "io"
+import "os"
+import "path"
+import "path/filepath"
+import "sort"
+import "strings"
+
+// A directory of static files and the directory of the build it is copied
+// into. Layers are copied in order, later layers overriding earlier ones.
+type StaticLayer struct {
+	Source string
+	Dest   string
+	Site   bool
+}
+
+// Returns the static layers of the site: the 'static' directory of each
+// theme, from the root-most parent to the active theme, copied to
+// 'build/static', followed by the workspace 'src/static' directory, copied
+// to the root of the build.
+func (m *Manager) StaticLayers() []StaticLayer {
+	layers := make([]StaticLayer, 0)
+	chain := m.Theme.Chain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		layers = append(layers, StaticLayer{filepath.Join(chain[i].Path, "static"), "static", false})
+	}
+	layers = append(layers, StaticLayer{filepath.Join(m.Fspath, "src", "static"), "", true})
+	return layers
+}
+
+// Finds the static file served at the root-relative url
+func (m *Manager) FindStatic(url string) (string, bool) {
+	rel := filepath.FromSlash(strings.TrimPrefix(url, "/"))
+	layers := m.StaticLayers()
+	for i := len(layers) - 1; i >= 0; i-- {
+		inlayer, err := filepath.Rel(layers[i].Dest, rel)
+		if err != nil || strings.HasPrefix(inlayer, "..") {
+			continue
+		}
+		path := filepath.Join(layers[i].Source, inlayer)
+		if Exists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Files never copied from static directories unless the site configures
+// its own static_ignore patterns.
+var DefaultStaticIgnore = []string{".DS_Store", "Thumbs.db", "*.swp", "*~", ".git"}
+
+// The outcome of a static synchronization
+type SyncStats struct {
+	Copied    int
+	Unchanged int
+	Removed   int
+}
+
+// Returns the static_ignore patterns of the site
+func (m *Manager) staticIgnore() []string {
+	return m.Site.StaticIgnore
+}
+
+// Whether the file at rel, relative to its static directory, matches an
+// ignore pattern. Patterns are matched against the file name and against
+// the slash separated relative path.
+func ignoredStatic(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the sha1 hash of the file at name
+func hashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Returns the static file of the site which provides each file of the
+// build, keyed by its path relative to the build directory. Returns a
+// warning for every site file that shadows a theme file.
+func (m *Manager) StaticFiles() (map[string]string, []string, error) {
+	ignore := m.staticIgnore()
+	warnings := make([]string, 0)
+	sources := make(map[string]string)
+	owners := make(map[string]StaticLayer)
+
+	for _, layer := range m.StaticLayers() {
+		if !Exists(layer.Source) {
+			continue
+		}
+		layer := layer
+		err := filepath.Walk(layer.Source, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(layer.Source, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			if ignoredStatic(ignore, rel) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			dest := filepath.Join(layer.Dest, rel)
+
+			if owner, ok := owners[dest]; ok && layer.Site && !owner.Site {
+				shadowed, _ := filepath.Rel(m.Fspath, sources[dest])
+				warnings = append(warnings, "'"+filepath.Join("src", "static", rel)+"' shadows theme file '"+shadowed+"'")
+			}
+			owners[dest] = layer
+			sources[dest] = path
+			return nil
+		})
+		if err != nil {
+			return nil, warnings, err
+		}
+	}
+	sort.Strings(warnings)
+	return sources, warnings, nil
+}
+
+// Whether the build file at dest, relative to the build directory, is
+// written by the asset pipeline in place of its static file: bundles and,
+// when they are minified, CSS and JavaScript assets which are not
+// fingerprinted
+func (m *Manager) assetOwned(dest string) bool {
+	if m.Site.AssetFingerprint {
+		return false
+	}
+	rel, err := filepath.Rel("static", dest)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	if _, ok := m.Site.AssetBundles[filepath.ToSlash(rel)]; ok {
+		return true
+	}
+	ext := filepath.Ext(rel)
+	return m.Site.AssetMinify && (ext == ".css" || ext == ".js")
+}
+
+// Synchronizes the static layers of the site into the build directory.
+// Only files which are new or whose size or content changed are copied,
+// and files synchronized by a previous build which no longer exist in any
+// layer are removed. Files the asset pipeline writes itself are left to
+// it. The synchronized files are recorded in the workspace '.goblinstatic'
+// file.
+func (m *Manager) SyncStatic() (SyncStats, []string, error) {
+	var stats SyncStats
+	builddir := filepath.Join(m.Fspath, "build")
+
+	sources, warnings, err := m.StaticFiles()
+	if err != nil {
+		return stats, warnings, err
+	}
+
+	records := NewConfig(filepath.Join(m.Fspath, ".goblinstatic"))
+	if Exists(records.filename) {
+		err = records.parse()
+		if err != nil {
+			return stats, warnings, err
+		}
+	}
+	synced := NewConfig(records.filename)
+
+	for dest, source := range sources {
+		if m.assetOwned(dest) {
+			continue
+		}
+		target := filepath.Join(builddir, dest)
+		sum, err := hashFile(source)
+		if err != nil {
+			return stats, warnings, err
+		}
+		synced.Set(filepath.ToSlash(dest), sum)
+
+		si, err := os.Stat(source)
+		if err != nil {
+			return stats, warnings, err
+		}
+		if ti, err := os.Stat(target); err == nil && ti.Size() == si.Size() {
+			if current, err := hashFile(target); err == nil && current == sum {
+				if ti.Mode().Perm() != si.Mode().Perm() {
+					os.Chmod(target, si.Mode().Perm())
+				}
+				stats.Unchanged++
+				continue
+			}
+		}
+
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return stats, warnings, err
+		}
+		os.Remove(target)
+		err = CopyFile(source, target)
+		if err != nil {
+			return stats, warnings, err
+		}
+		stats.Copied++
+	}
+
+	for dest := range records.data {
+		if _, ok := sources[filepath.FromSlash(dest)]; ok {
+			continue
+		}
+		target := filepath.Join(builddir, filepath.FromSlash(dest))
+		err := os.Remove(target)
+		if err != nil && !os.IsNotExist(err) {
+			return stats, warnings, err
+		}
+		stats.Removed++
+
+		// remove the directories the file leaves empty
+		for dir := filepath.Dir(target); dir != builddir && strings.HasPrefix(dir, builddir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	return stats, warnings, SaveConfig(synced)
+}
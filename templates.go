@@ -0,0 +1,62 @@
+package main
+
+import "io/ioutil"
+import "path/filepath"
+import "strings"
+
+import "github.com/flosch/pongo"
+
+// Returns the directories layouts are looked up in, in order of precedence:
+// the workspace 'layouts' directory, then the theme and each of its parents.
+func (m *Manager) LayoutDirs() []string {
+	dirs := []string{filepath.Join(m.Fspath, "layouts")}
+	for _, theme := range m.Theme.Chain() {
+		dirs = append(dirs, theme.Path)
+	}
+	return dirs
+}
+
+// Returns the directories included templates are looked up in, in order of
+// precedence: the workspace 'partials' and 'layouts' directories, then the
+// 'partials' directory and root of the theme and each of its parents.
+func (m *Manager) PartialDirs() []string {
+	dirs := []string{filepath.Join(m.Fspath, "partials"), filepath.Join(m.Fspath, "layouts")}
+	for _, theme := range m.Theme.Chain() {
+		dirs = append(dirs, filepath.Join(theme.Path, "partials"), theme.Path)
+	}
+	return dirs
+}
+
+// Finds the layout with the given name
+func (m *Manager) FindLayout(layout string) (string, bool) {
+	return FindTemplate(m.LayoutDirs(), layout+".html")
+}
+
+// Finds the template name in the first of dirs that contains it
+func FindTemplate(dirs []string, name string) (string, bool) {
+	rel := filepath.FromSlash(strings.TrimPrefix(name, "/"))
+	for _, dir := range dirs {
+		path := filepath.Join(dir, rel)
+		if Exists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Returns a pongo template locator that resolves included templates
+// through the given directories.
+func TemplateLocator(dirs []string) pongo.TemplateLocator {
+	return func(name *string) (*string, error) {
+		path, ok := FindTemplate(dirs, *name)
+		if !ok {
+			return nil, &CustomError{"template '" + *name + "' not found"}
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		content := string(raw)
+		return &content, nil
+	}
+}
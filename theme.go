@@ -0,0 +1,173 @@
+package main
+
+import "fmt"
+import "path/filepath"
+import "sort"
+import "strings"
+
+// A theme in the workspace 'themes' directory. A theme may name a parent in
+// its theme.json, in which case any layout, partial or static file it does
+// not provide itself is looked up in the parent chain.
+type Theme struct {
+	Name   string
+	Path   string
+	Parent *Theme
+	Config *Config
+}
+
+// Loads the named theme, and its parents, from the workspace at fspath
+func LoadTheme(fspath, name string) (*Theme, error) {
+	return loadTheme(fspath, name, make(map[string]bool))
+}
+
+func loadTheme(fspath, name string, seen map[string]bool) (*Theme, error) {
+	if name == "" {
+		return nil, &CustomError{"no theme given"}
+	}
+	if seen[name] {
+		return nil, &CustomError{"theme '" + name + "' inherits from itself"}
+	}
+	seen[name] = true
+
+	theme := &Theme{Name: name, Path: filepath.Join(fspath, "themes", name)}
+	if !Exists(theme.Path) {
+		return nil, &CustomError{"theme '" + name + "' does not exist"}
+	}
+
+	theme.Config = NewConfig(filepath.Join(theme.Path, "theme.json"))
+	if Exists(theme.Config.filename) {
+		err := theme.Config.parse()
+		if err != nil {
+			return nil, &CustomError{"cannot read theme.json of '" + name + "': " + err.Error()}
+		}
+	}
+
+	if parent := theme.Config.GetString("parent"); parent != "" {
+		var err error
+		theme.Parent, err = loadTheme(fspath, parent, seen)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return theme, nil
+}
+
+// Returns the theme followed by each of its parents
+func (t *Theme) Chain() []*Theme {
+	chain := make([]*Theme, 0)
+	for theme := t; theme != nil; theme = theme.Parent {
+		chain = append(chain, theme)
+	}
+	return chain
+}
+
+// Finds the file name, relative to the theme directory, in the first theme
+// of the chain that provides it.
+func (t *Theme) Lookup(name string) (string, bool) {
+	rel := filepath.FromSlash(strings.TrimPrefix(name, "/"))
+	for _, theme := range t.Chain() {
+		path := filepath.Join(theme.Path, rel)
+		if Exists(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Returns the parameters of the theme chain, as declared in the 'params' of
+// each theme.json, with values from the site's theme_params applied over
+// their defaults. Site values which the theme does not declare are reported
+// as warnings.
+func (t *Theme) Params(site map[string]interface{}) (map[string]interface{}, []string, error) {
+	declared := make(map[string]map[string]interface{})
+	chain := t.Chain()
+	for i := len(chain) - 1; i >= 0; i-- {
+		for name, decl := range chain[i].Config.GetMap("params") {
+			d, ok := decl.(map[string]interface{})
+			if !ok {
+				return nil, nil, &CustomError{fmt.Sprintf("param '%s' of theme '%s' must be an object with a type and default", name, chain[i].Name)}
+			}
+			declared[name] = d
+		}
+	}
+
+	params := make(map[string]interface{})
+	for name, decl := range declared {
+		kind, _ := decl["type"].(string)
+		if kind == "" {
+			kind = "string"
+		}
+		if _, ok := paramKinds[kind]; !ok {
+			return nil, nil, &CustomError{fmt.Sprintf("theme param '%s' has unknown type '%s'", name, kind)}
+		}
+
+		value, present := site[name]
+		if !present {
+			value = decl["default"]
+			if value == nil {
+				value = paramKinds[kind]
+			}
+		}
+		value, ok := checkParam(kind, value)
+		if !ok {
+			if present {
+				return nil, nil, &CustomError{fmt.Sprintf("theme_params.%s must be of type %s", name, kind)}
+			}
+			return nil, nil, &CustomError{fmt.Sprintf("default of theme param '%s' must be of type %s", name, kind)}
+		}
+		params[name] = value
+	}
+
+	warnings := make([]string, 0)
+	for name := range site {
+		if _, ok := declared[name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("theme_params.%s is not a parameter of theme '%s'", name, t.Name))
+		}
+	}
+	sort.Strings(warnings)
+	return params, warnings, nil
+}
+
+// The types a theme param may declare, with the zero value of each
+var paramKinds = map[string]interface{}{
+	"string": "",
+	"int":    0,
+	"float":  0.0,
+	"bool":   false,
+	"list":   []interface{}{},
+	"map":    map[string]interface{}{},
+}
+
+// Checks that a decoded JSON value is of the given param type, converting
+// whole numbers to int for int params.
+func checkParam(kind string, value interface{}) (interface{}, bool) {
+	switch kind {
+	case "string":
+		_, ok := value.(string)
+		return value, ok
+	case "int":
+		switch v := value.(type) {
+		case int:
+			return v, true
+		case float64:
+			return int(v), v == float64(int(v))
+		}
+	case "float":
+		switch v := value.(type) {
+		case int:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+	case "bool":
+		_, ok := value.(bool)
+		return value, ok
+	case "list":
+		_, ok := value.([]interface{})
+		return value, ok
+	case "map":
+		_, ok := value.(map[string]interface{})
+		return value, ok
+	}
+	return value, false
+}
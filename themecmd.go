@@ -0,0 +1,392 @@
+package main
+
+import "archive/tar"
+import "archive/zip"
+import "compress/gzip"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+
+// The layouts every installed theme must provide, either itself or through
+// its parents.
+var RequiredLayouts = []string{"page", "post", "list", "taxonomy", "404"}
+
+// Prints the themes installed in the workspace, marking the active theme
+func ListThemes(m *Manager) error {
+	entries, err := ioutil.ReadDir(filepath.Join(m.Fspath, "themes"))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		marker := " "
+		if entry.Name() == m.Site.Theme {
+			marker = "*"
+		}
+
+		theme, err := LoadTheme(m.Fspath, entry.Name())
+		if err != nil {
+			fmt.Printf("%s %s (%s)\n", marker, entry.Name(), err)
+			continue
+		}
+
+		line := marker + " " + theme.Name
+		if version := theme.Config.GetString("version"); version != "" {
+			line += " " + version
+		}
+		if theme.Parent != nil {
+			line += " (inherits " + theme.Parent.Name + ")"
+		}
+		if description := theme.Config.GetString("description"); description != "" {
+			line += " - " + description
+		}
+		if author := theme.Config.GetString("author"); author != "" {
+			line += " by " + author
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// Returns the directory of a theme in the workspace, refusing names which
+// are not a single directory directly under themes/
+func themeDir(m *Manager, name string) (string, error) {
+	themesdir := filepath.Join(m.Fspath, "themes")
+	dir := filepath.Join(themesdir, name)
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") || filepath.Dir(dir) != themesdir {
+		return "", &CustomError{"invalid theme name '" + name + "'"}
+	}
+	return dir, nil
+}
+
+// Creates a theme skeleton with every required layout
+func NewTheme(m *Manager, name, parent string) error {
+	dir, err := themeDir(m, name)
+	if err != nil {
+		return err
+	}
+	if Exists(dir) {
+		return &CustomError{"theme '" + name + "' already exists"}
+	}
+	if parent != "" {
+		parentdir, err := themeDir(m, parent)
+		if err != nil {
+			return err
+		}
+		if !Exists(parentdir) {
+			return &CustomError{"parent theme '" + parent + "' does not exist"}
+		}
+	}
+
+	config := NewConfig(filepath.Join(dir, "theme.json"))
+	config.Set("name", name)
+	config.Set("description", "")
+	config.Set("author", m.Site.Author)
+	config.Set("version", "0.1")
+	if parent != "" {
+		config.Set("parent", parent)
+	}
+
+	files := map[string]string{
+		"partials/head.html":   ThemeSkeletonHead,
+		"partials/header.html": ThemeSkeletonHeader,
+		"partials/footer.html": ThemeSkeletonFooter,
+		"static/css/style.css": "",
+	}
+	// a child theme inherits its layouts from the parent
+	if parent == "" {
+		for _, layout := range RequiredLayouts {
+			files[layout+".html"] = ThemeSkeletonLayout
+		}
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		err = CreateSimpleFile(path, contents, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return SaveConfig(config)
+}
+
+// Installs a theme from a directory, .zip or .tar.gz archive into the
+// workspace. The theme is named by its theme.json, falling back to the name
+// of the source, unless a name is given.
+func InstallTheme(m *Manager, source, name string, force bool) error {
+	themesdir := filepath.Join(m.Fspath, "themes")
+	err := os.MkdirAll(themesdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpdir, err := ioutil.TempDir(themesdir, ".install-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	lower := strings.ToLower(source)
+	switch {
+	case fi.IsDir():
+		err = CopyDir(source, filepath.Join(tmpdir, "theme"))
+	case strings.HasSuffix(lower, ".zip"):
+		err = unzipTheme(source, tmpdir)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		err = untarTheme(source, tmpdir)
+	default:
+		err = &CustomError{"themes must be a directory, .zip or .tar.gz archive"}
+	}
+	if err != nil {
+		return err
+	}
+
+	root, err := themeRoot(tmpdir)
+	if err != nil {
+		return err
+	}
+
+	theme := &Theme{Path: root, Config: NewConfig(filepath.Join(root, "theme.json"))}
+	if Exists(theme.Config.filename) {
+		err = theme.Config.parse()
+		if err != nil {
+			return &CustomError{"cannot read theme.json: " + err.Error()}
+		}
+	}
+
+	if name == "" {
+		name = theme.Config.GetString("name")
+	}
+	if name == "" {
+		name = filepath.Base(source)
+		for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+			if strings.HasSuffix(strings.ToLower(name), ext) {
+				name = name[:len(name)-len(ext)]
+			}
+		}
+	}
+	dest, err := themeDir(m, name)
+	if err != nil {
+		return err
+	}
+	theme.Name = name
+
+	if parent := theme.Config.GetString("parent"); parent != "" {
+		theme.Parent, err = LoadTheme(m.Fspath, parent)
+		if err != nil {
+			return &CustomError{"cannot load parent theme: " + err.Error()}
+		}
+	}
+	missing := make([]string, 0)
+	for _, layout := range RequiredLayouts {
+		if _, ok := theme.Lookup(layout + ".html"); !ok {
+			missing = append(missing, layout)
+		}
+	}
+	if len(missing) > 0 {
+		return &CustomError{"theme '" + name + "' is missing required layouts: " + strings.Join(missing, ", ")}
+	}
+
+	if Exists(dest) {
+		if !force {
+			return &CustomError{"theme '" + name + "' is already installed"}
+		}
+		err = os.RemoveAll(dest)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(root, dest)
+}
+
+// Removes an installed theme. The active theme and themes that others
+// inherit from are only removed when forced.
+func RemoveTheme(m *Manager, name string, force bool) error {
+	dir, err := themeDir(m, name)
+	if err != nil {
+		return err
+	}
+	if !Exists(dir) {
+		return &CustomError{"theme '" + name + "' is not installed"}
+	}
+
+	if !force {
+		if name == m.Site.Theme {
+			return &CustomError{"theme '" + name + "' is the active theme"}
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(m.Fspath, "themes"))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || entry.Name() == name {
+				continue
+			}
+			theme, err := LoadTheme(m.Fspath, entry.Name())
+			if err == nil && theme.Parent != nil && theme.Parent.Name == name {
+				return &CustomError{"theme '" + entry.Name() + "' inherits from '" + name + "'"}
+			}
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// Returns the directory of an unpacked theme. Archives usually contain a
+// single top level directory holding the theme.
+func themeRoot(dir string) (string, error) {
+	for {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return "", err
+		}
+		if len(entries) != 1 || !entries[0].IsDir() || Exists(filepath.Join(dir, "theme.json")) {
+			return dir, nil
+		}
+		dir = filepath.Join(dir, entries[0].Name())
+	}
+}
+
+// Returns the path an archive entry is unpacked to, refusing entries which
+// would be written outside of dest.
+func archivePath(dest, name string) (string, error) {
+	path := filepath.Join(dest, filepath.FromSlash(name))
+	if path != dest && !strings.HasPrefix(path, dest+string(filepath.Separator)) {
+		return "", &CustomError{"archive entry '" + name + "' is outside of the theme"}
+	}
+	return path, nil
+}
+
+// Writes the contents of r to a new file at path
+func writeArchiveFile(path string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, r)
+	return err
+}
+
+func unzipTheme(source, dest string) error {
+	archive, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, entry := range archive.File {
+		path, err := archivePath(dest, entry.Name)
+		if err != nil {
+			return err
+		}
+		if entry.FileInfo().IsDir() {
+			err = os.MkdirAll(path, 0755)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		r, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(path, r, entry.Mode())
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func untarTheme(source, dest string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := archivePath(dest, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			err = writeArchiveFile(path, archive, os.FileMode(header.Mode))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+const ThemeSkeletonHead = `<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<link rel="stylesheet" href="{{ "css/style.css"|asset }}">
+`
+
+const ThemeSkeletonHeader = `<header>
+    <a href="{{ "/"|relurl }}">{{ site_title }}</a>
+</header>
+`
+
+const ThemeSkeletonFooter = `<footer>
+    <p>{{ site_copyright }}</p>
+</footer>
+`
+
+const ThemeSkeletonLayout = `<!DOCTYPE html>
+<html>
+<head>
+    {% include "head.html" %}
+    <title>{{ page_title }} | {{ site_title }}</title>
+</head>
+<body>
+    {% include "header.html" %}
+    <main>
+        {{ content|safe }}
+    </main>
+    {% include "footer.html" %}
+</body>
+</html>
+`
@@ -1,6 +1,8 @@
 package main
 
+import "io/ioutil"
 import "os"
+import "path/filepath"
 
 // Check if File / Directory Exists
 func Exists(path string) bool {
@@ -18,10 +20,54 @@ func IfTrueExec(thing bool, fn func(string, ...interface{}), format string, args
 }
 
 func CreateSimpleFile(name, contents string, mode uint32) error {
-    file, err := os.OpenFile(name, os.O_CREATE, os.FileMode(mode))
-    defer file.Close()
+    file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(mode))
 	if err != nil { return err }
+    defer file.Close()
     
-    file.WriteString(contents)
-    return nil
+    _, err = file.WriteString(contents)
+    return err
+}
+
+// Writes a file atomically: the contents go to a temporary file in the same
+// directory which then replaces the file, so that readers never see it
+// partially written. An existing file keeps its permissions.
+func WriteFileAtomic(name string, contents []byte, mode os.FileMode) error {
+	if fi, err := os.Stat(name); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(contents)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Lowercases the ASCII letters of s only. Unlike strings.ToLower it keeps
+// every byte where it was, so that indices into the result are indices
+// into s.
+func LowerASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
 }
@@ -0,0 +1,281 @@
+package main
+
+import "fmt"
+import "net/http"
+import "os"
+import "path/filepath"
+import "strings"
+import "sync"
+import "time"
+
+import "github.com/fsnotify/fsnotify"
+
+// The workspace directories whose changes trigger a rebuild
+var WatchedDirs = []string{"src", "themes", "layouts", "partials"}
+
+// Changes in these workspace directories require the theme to be reloaded
+// and every page to be rebuilt
+var templateDirs = map[string]bool{"themes": true, "layouts": true, "partials": true}
+
+// How long to wait for further changes before rebuilding
+const watchDelay = 100 * time.Millisecond
+
+// Watches the workspace of a builder, rebuilding the site whenever its
+// sources change and notifying the browsers connected to reload.
+func (b *Builder) Watch(reload *LiveReload) error {
+	return WatchWorkspace(b.Manager.Fspath, func(changed map[string]bool) {
+		b.rebuild(changed, reload)
+	})
+}
+
+// Watches the sources of a workspace, calling changed with the workspace
+// relative paths of the files changed, once further changes have settled.
+func WatchWorkspace(root string, changed func(map[string]bool)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// the workspace root itself is watched for its config file
+	err = watcher.Add(root)
+	if err != nil {
+		return err
+	}
+	for _, dir := range WatchedDirs {
+		if Exists(filepath.Join(root, dir)) {
+			err = watchTree(watcher, filepath.Join(root, dir))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	go func() {
+		pending := make(map[string]bool)
+		var delay <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				rel, watched := watchedPath(root, event.Name)
+				if !watched {
+					continue
+				}
+				if event.Op&fsnotify.Create != 0 {
+					if fi, err := os.Stat(event.Name); err == nil && fi.IsDir() {
+						err = watchTree(watcher, event.Name)
+						if err != nil {
+							OUT.Errorf("cannot watch '%s': %s", event.Name, err)
+						}
+					}
+				}
+				pending[rel] = true
+				delay = time.After(watchDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				OUT.Errorf("watch error: %s", err)
+			case <-delay:
+				changed(pending)
+				pending = make(map[string]bool)
+				delay = nil
+			}
+		}
+	}()
+	return nil
+}
+
+// Adds a directory and all of its subdirectories to a watcher
+func watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path != dir && strings.HasPrefix(fi.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// Returns the path of a changed file relative to the workspace, and whether
+// the change is relevant to the build. Hidden files, editor backups and,
+// at the workspace root, anything but the config and redirects files are
+// ignored.
+func watchedPath(root, name string) (string, bool) {
+	rel, err := filepath.Rel(root, name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") || strings.HasSuffix(part, "~") {
+			return "", false
+		}
+	}
+	if len(parts) == 1 {
+		return rel, isConfigName(rel) || rel == "redirects"
+	}
+	return rel, true
+}
+
+// Rebuilds the site after the given workspace files changed
+func (b *Builder) rebuild(changed map[string]bool, reload *LiveReload) {
+	full := false
+	styles := true
+	for rel := range changed {
+		top := strings.Split(filepath.ToSlash(rel), "/")[0]
+		if isConfigName(rel) || templateDirs[top] {
+			full = true
+		}
+		if strings.ToLower(filepath.Ext(rel)) != ".css" {
+			styles = false
+		}
+	}
+
+	start := time.Now()
+	if full {
+		err := b.Reload()
+		if err != nil {
+			OUT.Errorf("%s", err)
+			return
+		}
+	}
+	err := b.Build(full)
+	if err != nil {
+		OUT.Errorf("%s", err)
+		return
+	}
+	OUT.Infof("rebuilt site in %s (%d changed files)", time.Since(start).Round(time.Millisecond), len(changed))
+
+	// stylesheets are swapped in place as long as no page had to change
+	if styles && !full && !b.Manager.Assets.Changed {
+		reload.Notify("css")
+	} else {
+		reload.Notify("reload")
+	}
+}
+
+// Reloads the configuration and theme of the site. The current manager is
+// kept if the configuration cannot be loaded.
+func (b *Builder) Reload() error {
+	manager, err := LoadManager(b.Manager.Fspath, b.Manager.Env)
+	if err != nil {
+		return err
+	}
+	err = manager.LoadTheme()
+	if err != nil {
+		return err
+	}
+	b.Manager = manager
+	return nil
+}
+
+// The path browsers listen on for live reload events
+const LiveReloadPath = "/_goblin/livereload"
+
+// The script injected into served pages in watch mode. A "css" event swaps
+// the stylesheets of the page, anything else reloads it.
+const liveReloadScript = `<script>(function () {
+	var source = new EventSource("` + LiveReloadPath + `");
+	source.onmessage = function (event) {
+		if (event.data !== "css") {
+			location.reload();
+			return;
+		}
+		var links = document.querySelectorAll("link[rel=stylesheet]");
+		for (var i = 0; i < links.length; i++) {
+			var url = links[i].href.replace(/[?&]_goblin=\d+/, "");
+			links[i].removeAttribute("integrity");
+			links[i].href = url + (url.indexOf("?") < 0 ? "?" : "&") + "_goblin=" + Date.now();
+		}
+	};
+})();</script>`
+
+// Broadcasts reload events to connected browsers as server-sent events
+type LiveReload struct {
+	mutex   sync.Mutex
+	clients map[chan string]bool
+	done    chan struct{}
+	closed  bool
+}
+
+func NewLiveReload() *LiveReload {
+	return &LiveReload{clients: make(map[chan string]bool), done: make(chan struct{})}
+}
+
+// Disconnects every browser, so that the server can shut down
+func (lr *LiveReload) Close() {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	if !lr.closed {
+		lr.closed = true
+		close(lr.done)
+	}
+}
+
+// Sends an event to every connected browser
+func (lr *LiveReload) Notify(event string) {
+	lr.mutex.Lock()
+	defer lr.mutex.Unlock()
+	for client := range lr.clients {
+		select {
+		case client <- event:
+		default:
+			// the client still has an event pending
+		}
+	}
+}
+
+func (lr *LiveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	client := make(chan string, 1)
+	lr.mutex.Lock()
+	lr.clients[client] = true
+	lr.mutex.Unlock()
+	defer func() {
+		lr.mutex.Lock()
+		delete(lr.clients, client)
+		lr.mutex.Unlock()
+	}()
+
+	for {
+		select {
+		case event := <-client:
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-lr.done:
+			return
+		}
+	}
+}
+
+// Injects the live reload script into a page, before its closing body tag
+// if it has one
+func InjectLiveReload(html []byte) []byte {
+	i := strings.LastIndex(LowerASCII(string(html)), "</body>")
+	if i < 0 {
+		return append(html, liveReloadScript...)
+	}
+	out := make([]byte, 0, len(html)+len(liveReloadScript))
+	out = append(out, html[:i]...)
+	out = append(out, liveReloadScript...)
+	return append(out, html[i:]...)
+}
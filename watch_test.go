@@ -0,0 +1,23 @@
+package main
+
+import "strings"
+import "testing"
+
+func TestInjectLiveReload(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"body", "<body><p>x</p></body></html>", "<body><p>x</p>" + liveReloadScript + "</body></html>"},
+		{"uppercase body", "<BODY>x</BODY>", "<BODY>x" + liveReloadScript + "</BODY>"},
+		{"last body", "<body><pre></body></pre></body>", "<body><pre></body></pre>" + liveReloadScript + "</body>"},
+		{"no body", "<p>x</p>", "<p>x</p>" + liveReloadScript},
+		{"non-ascii", "<body>" + strings.Repeat("İ", 10) + "</body>", "<body>" + strings.Repeat("İ", 10) + liveReloadScript + "</body>"},
+	}
+	for _, test := range tests {
+		if got := string(InjectLiveReload([]byte(test.in))); got != test.want {
+			t.Errorf("%s: InjectLiveReload(%q) = %q, want %q", test.name, test.in, got, test.want)
+		}
+	}
+}